@@ -1,7 +1,10 @@
 package natsproxy
 
 import (
-	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
@@ -19,8 +22,18 @@ const (
 	PUT = "PUT"
 	// DELETE method constant
 	DELETE = "DELETE"
+	// PATCH method constant
+	PATCH = "PATCH"
+	// HEAD method constant
+	HEAD = "HEAD"
+	// OPTIONS method constant
+	OPTIONS = "OPTIONS"
 )
 
+// anyMethods are the methods
+// subscribed by Any helper.
+var anyMethods = []string{GET, POST, PUT, PATCH, DELETE, HEAD, OPTIONS}
+
 // NatsHandler handles the
 // tranforrmed HTTP request from
 // NatsProxy. The context c wraps the
@@ -36,18 +49,42 @@ type NatsHandlers []NatsHandler
 // Connector is the interface for
 // generic pub/sub client.
 type Connector interface {
-	Subscribe(url string, handler NatsHandler)
-	UnSubscribe(url string, handler NatsHandler)
+	Subscribe(method, url string, handlers ...NatsHandler) (*Subscription, error)
+	Unsubscribe(method, url string) error
 }
 
 // NatsClient serves as Connector
 // to NATS messaging. Allows to subscribe
 // for an specific url or url pattern.
 type NatsClient struct {
-	conn    *nats.Conn
-	filters NatsHandlers
-	reqPool RequestPool
-	resPool ResponsePool
+	inFlight   int64 // accessed atomically, kept 64-bit aligned
+	conn       *nats.Conn
+	filters    NatsHandlers
+	reqPool    RequestPool
+	resPool    ResponsePool
+	timeout    time.Duration
+	queueGroup string
+	mu         sync.RWMutex
+	routes     map[string]*Subscription
+	autoRoutes map[string]*nats.Subscription
+	routeTree  *routeTree
+	autoTree   *routeTree
+	wsSubs     map[string][]*nats.Subscription
+	wsEvents   map[string][]WebsocketEventHandler
+	closed     bool
+
+	autoHeadOptions        bool
+	handleMethodNotAllowed bool
+	strictRoutes           bool
+	constraintStatus       int
+
+	recoveryHandler RecoveryHandler
+	panicLogger     PanicLogger
+	propagatePanics bool
+
+	metrics  *Metrics
+	exporter Exporter
+	logger   Logger
 }
 
 // NewNatsClient creates new NATS client
@@ -59,86 +96,473 @@ func NewNatsClient(conn *nats.Conn) (*NatsClient, error) {
 		return nil, err
 	}
 	return &NatsClient{
-		conn,
-		make([]NatsHandler, 0),
-		NewRequestPool(),
-		NewResponsePool(),
+		conn:       conn,
+		filters:    make([]NatsHandler, 0),
+		reqPool:    NewRequestPool(),
+		resPool:    NewResponsePool(),
+		timeout:    DefaultClientTimeout,
+		routes:     make(map[string]*Subscription),
+		autoRoutes: make(map[string]*nats.Subscription),
+		routeTree:  newRouteTree(),
+		autoTree:   newRouteTree(),
+		wsSubs:     make(map[string][]*nats.Subscription),
+		wsEvents:   make(map[string][]WebsocketEventHandler),
+
+		autoHeadOptions: true,
+
+		recoveryHandler: DefaultRecoveryHandler,
+		logger:          NopLogger{},
 	}, nil
 }
 
 // Use will add the middleware NatsHandler
-// for a client.
-func (nc *NatsClient) Use(middleware NatsHandler) {
-	nc.filters = append(nc.filters, middleware)
+// for a client. The middleware is
+// applied to all routes of the client.
+func (nc *NatsClient) Use(middleware ...NatsHandler) {
+	nc.filters = append(nc.filters, middleware...)
 }
 
 // GET subscribes the client
 // for an url with GET method.
-func (nc *NatsClient) GET(url string, handler NatsHandler) {
-	nc.Subscribe(GET, url, handler)
+func (nc *NatsClient) GET(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(GET, url, handlers...)
 }
 
 // POST subscribes the client
 // for an url with POST method.
-func (nc *NatsClient) POST(url string, handler NatsHandler) {
-	nc.Subscribe(POST, url, handler)
+func (nc *NatsClient) POST(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(POST, url, handlers...)
 }
 
 // PUT subscribes the client
 // for an url with PUT method.
-func (nc *NatsClient) PUT(url string, handler NatsHandler) {
-	nc.Subscribe(PUT, url, handler)
+func (nc *NatsClient) PUT(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(PUT, url, handlers...)
 }
 
 // DELETE subscribes the client
 // for an url with DELETE method.
-func (nc *NatsClient) DELETE(url string, handler NatsHandler) {
-	nc.Subscribe(DELETE, url, handler)
+func (nc *NatsClient) DELETE(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(DELETE, url, handlers...)
+}
+
+// PATCH subscribes the client
+// for an url with PATCH method.
+func (nc *NatsClient) PATCH(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(PATCH, url, handlers...)
+}
+
+// HEAD subscribes the client
+// for an url with HEAD method.
+// The HEAD requests of url with GET
+// route are handled automatically if
+// no HEAD route is subscribed.
+func (nc *NatsClient) HEAD(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(HEAD, url, handlers...)
+}
+
+// OPTIONS subscribes the client
+// for an url with OPTIONS method.
+// The OPTIONS requests are handled
+// automatically if no OPTIONS
+// route is subscribed.
+func (nc *NatsClient) OPTIONS(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.Subscribe(OPTIONS, url, handlers...)
+}
+
+// Any subscribes the client for
+// an url with GET, POST, PUT, PATCH,
+// DELETE, HEAD and OPTIONS method.
+func (nc *NatsClient) Any(url string, handlers ...NatsHandler) ([]*Subscription, error) {
+	subs := make([]*Subscription, 0, len(anyMethods))
+	for _, method := range anyMethods {
+		s, err := nc.Subscribe(method, url, handlers...)
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+// SetAutoHeadOptions enables or disables
+// the automatic responses to HEAD requests,
+// derived from GET route, and OPTIONS requests
+// with Allow header. It is enabled by default
+// and applies to routes subscribed afterwards.
+func (nc *NatsClient) SetAutoHeadOptions(enabled bool) {
+	nc.autoHeadOptions = enabled
+}
+
+// SetHandleMethodNotAllowed enables or disables
+// the 405 Method Not Allowed responses for the
+// methods not subscribed for url that has
+// routes under other methods. It is disabled by default,
+// because it requires the client to own all methods
+// of its urls. It applies to routes subscribed afterwards.
+func (nc *NatsClient) SetHandleMethodNotAllowed(enabled bool) {
+	nc.handleMethodNotAllowed = enabled
+}
+
+// SetQueueGroup sets the queue group
+// used for all subscriptions of the client.
+// The replicas of the service subscribed with
+// the same group load-balance the requests.
+// Empty group means the group name is derived
+// from the method and url of the route.
+func (nc *NatsClient) SetQueueGroup(group string) {
+	nc.queueGroup = group
 }
 
 // Subscribe is a generic subscribe function
 // for any http method. It also
 // wraps the processing of the context.
-func (nc *NatsClient) Subscribe(method, url string, handler NatsHandler) {
+// The handlers are chained after the
+// middleware of the client, the last one
+// is usually the route handler.
+// The subscription joins the queue group
+// of the client.
+func (nc *NatsClient) Subscribe(method, url string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.SubscribeQueue(method, url, nc.queueGroupFor(method, url), handlers...)
+}
+
+// SubscribeQueue subscribes the handler
+// for the method and url within given
+// queue group, so only one member of the
+// group handles the request.
+func (nc *NatsClient) SubscribeQueue(method, url, group string, handlers ...NatsHandler) (*Subscription, error) {
+	return nc.subscribe(method, url, group, nil, handlers)
+}
+
+// subscribe subscribes the route handlers
+// which are chained after the middleware
+// of the client and of the route group rg.
+func (nc *NatsClient) subscribe(method, url, group string, rg *RouterGroup, handlers NatsHandlers) (*Subscription, error) {
+	if err := validRoute(url); err != nil {
+		return nil, err
+	}
+	constraints, err := compileConstraints(url)
+	if err != nil {
+		return nil, err
+	}
 	subscribeURL := SubscribeURLToNats(method, url)
-	paramMap := buildParamMap(url)
-	nc.conn.Subscribe(subscribeURL, func(m *nats.Msg) {
-		request := nc.reqPool.GetRequest()
-		defer nc.reqPool.Put(request)
-		if err := request.UnmarshallFrom(m.Data); err != nil {
-			log.Println(err)
-			return
-		}
-		response := nc.resPool.GetResponse()
-		defer nc.resPool.Put(response)
-		c := newContext(paramMap, response, request)
-
-		// Iterate through filters
-		for _, filter := range nc.filters {
-			filter(c)
-			c.index++
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.closed {
+		return nil, ErrNatsClientClosed
+	}
+	if _, ok := nc.routes[subscribeURL]; ok {
+		return nil, ErrRouteExists
+	}
+	if err := nc.checkRoute(method, url, subscribeURL); err != nil {
+		return nil, err
+	}
+
+	// The explicit route replaces
+	// the automatic one.
+	if auto, ok := nc.autoRoutes[subscribeURL]; ok {
+		auto.Drain()
+		delete(nc.autoRoutes, subscribeURL)
+		nc.autoTree.remove(subscribeURL)
+	}
+
+	s := &Subscription{
+		Method:   method,
+		URL:      url,
+		Subject:  subscribeURL,
+		Group:    group,
+		client:   nc,
+		params:   buildParamMap(url),
+		group:    rg,
+		handlers: handlers,
+
+		constraints: constraints,
+	}
+	route := &routeEntry{
+		subject:     subscribeURL,
+		group:       group,
+		constraints: constraints,
+		handler: func(m *nats.Msg) {
+			nc.serve(m, s, nil)
+		},
+	}
+	sub, err := nc.conn.QueueSubscribe(subscribeURL, group, func(m *nats.Msg) {
+		nc.dispatch(m, subscribeURL, group, route.handler)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+	nc.routes[subscribeURL] = s
+	nc.routeTree.insert(route)
+	nc.ensureAutoRoutes(url)
+	return s, nil
+}
+
+// serve processes the request message
+// by the handler chain and publishes the
+// response to the reply subject. The finish
+// function could modify the response
+// before it is published.
+func (nc *NatsClient) serve(m *nats.Msg, s *Subscription, finish NatsHandler) {
+	atomic.AddInt64(&nc.inFlight, 1)
+	defer atomic.AddInt64(&nc.inFlight, -1)
+
+	request := nc.reqPool.GetRequest()
+	defer nc.reqPool.Put(request)
+	if err := request.UnmarshallFrom(m.Data); err != nil {
+		nc.logger.Error("cannot decode request", "subject", m.Subject, "error", err)
+		return
+	}
+	response := nc.resPool.GetResponse()
+	defer nc.resPool.PutResponse(response)
+	c := newContext(s.params, response, request)
+	defer c.release()
+	c.conn = nc.conn
+	c.reply = m.Reply
+	c.route = s.URL
+	c.handlers = nc.combineHandlers(s.group, s.handlers)
+
+	// The span of the handler is
+	// the child of the proxy span.
+	c.span = startSpan(s.Method+" "+s.URL, request.TraceParent, nc.exporter)
+	c.span.SetAttribute("http.method", request.Method)
+	c.span.SetAttribute("http.route", s.URL)
+	c.span.SetAttribute("nats.subject", m.Subject)
+	start := time.Now()
+	if s.constraints.acceptTokens(strings.Split(m.Subject, ".")) {
+		nc.runChain(c)
+	} else {
+		nc.rejectPath(c)
+	}
+	nc.metrics.handlerDone(c.route, request.Method, time.Since(start))
+	c.span.SetAttribute("http.status_code", strconv.Itoa(int(c.Response.StatusCode)))
+	c.span.finish()
+
+	// The streamed response has
+	// the header already sent.
+	if c.isStreaming() {
+		if err := c.bodyWriter.Close(); err != nil {
+			nc.logger.Warn("cannot close response stream", "request_id", request.RequestID, "error", err)
 		}
+		return
+	}
 
-		// If request is aborted do
-		// not proceed to handler.
-		if !c.IsAborted() {
-			handler(c)
+	// The response of event
+	// stream was already sent.
+	if c.replied {
+		return
+	}
+
+	if finish != nil {
+		finish(c)
+	}
+
+	// The requester gave up,
+	// the reply would be dropped.
+	if c.IsExpired() {
+		return
+	}
+
+	bytes, err := proto.Marshal(c.Response)
+	if err != nil {
+		nc.logger.Error("cannot encode response", "request_id", request.RequestID, "error", err)
+		return
+	}
+	nc.conn.Publish(m.Reply, bytes)
+}
+
+// ensureAutoRoutes subscribes the automatic
+// HEAD, OPTIONS and 405 handlers for the
+// methods of url without explicit route.
+// Must be called with nc.mu held.
+func (nc *NatsClient) ensureAutoRoutes(url string) {
+	for _, method := range anyMethods {
+		subject := SubscribeURLToNats(method, url)
+		if _, ok := nc.routes[subject]; ok {
+			continue
+		}
+		if _, ok := nc.autoRoutes[subject]; ok {
+			continue
 		}
-		bytes, err := proto.Marshal(c.Response)
+		if method == HEAD || method == OPTIONS {
+			if !nc.autoHeadOptions {
+				continue
+			}
+		} else if !nc.handleMethodNotAllowed {
+			continue
+		}
+		constraints, _ := compileConstraints(url)
+		route := &routeEntry{
+			subject:     subject,
+			group:       nc.queueGroupFor(method, url),
+			handler:     nc.autoHandler(method, url, constraints),
+			constraints: constraints,
+		}
+		sub, err := nc.conn.QueueSubscribe(subject, route.group, func(m *nats.Msg) {
+			nc.dispatch(m, route.subject, route.group, route.handler)
+		})
 		if err != nil {
-			log.Println(err)
+			nc.logger.Error("cannot subscribe route", "subject", subject, "error", err)
+			continue
+		}
+		nc.autoRoutes[subject] = sub
+		nc.autoTree.insert(route)
+	}
+}
+
+// removeAutoRoutes unsubscribes the automatic
+// handlers of url if the url has no explicit
+// route left, otherwise the missing
+// automatic handlers are subscribed.
+// Must be called with nc.mu held.
+func (nc *NatsClient) removeAutoRoutes(url string) {
+	if len(nc.allowedMethods(url)) > 0 {
+		nc.ensureAutoRoutes(url)
+		return
+	}
+	for _, method := range anyMethods {
+		subject := SubscribeURLToNats(method, url)
+		if auto, ok := nc.autoRoutes[subject]; ok {
+			auto.Drain()
+			delete(nc.autoRoutes, subject)
+			nc.autoTree.remove(subject)
+		}
+	}
+}
+
+// autoHandler handles the method of url
+// without explicit route. The HEAD request
+// is served by the GET route without the
+// response body, the OPTIONS request results
+// in 204 and other methods in 405 response,
+// both with Allow header.
+func (nc *NatsClient) autoHandler(method, url string, constraints pathConstraints) nats.MsgHandler {
+	paramMap := buildParamMap(url)
+	return func(m *nats.Msg) {
+		nc.mu.Lock()
+		get, hasGet := nc.routes[SubscribeURLToNats(GET, url)]
+		allowed := nc.allowedMethods(url)
+		nc.mu.Unlock()
+
+		if method == HEAD && hasGet {
+			nc.serve(m, get, stripBody)
 			return
 		}
-		nc.conn.Publish(m.Reply, bytes)
-	})
+		nc.serve(m, &Subscription{
+			Method:      method,
+			URL:         url,
+			params:      paramMap,
+			constraints: constraints,
+			handlers: NatsHandlers{func(c *Context) {
+				allow := strings.Join(allowed, ", ")
+				c.Response.GetHeader().Set("Allow", allow)
+				c.Response.Body = c.Response.Body[:0]
+				if method == OPTIONS {
+					c.Response.StatusCode = 204
+					return
+				}
+				c.Response.StatusCode = 405
+			}},
+		}, nil)
+	}
+}
+
+// allowedMethods returns the methods
+// which the url could be requested with.
+// Must be called with nc.mu held.
+func (nc *NatsClient) allowedMethods(url string) []string {
+	allowed := make([]string, 0, len(anyMethods))
+	for _, method := range anyMethods {
+		if _, ok := nc.routes[SubscribeURLToNats(method, url)]; ok {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) == 0 || !nc.autoHeadOptions {
+		return allowed
+	}
+	_, hasGet := nc.routes[SubscribeURLToNats(GET, url)]
+	_, hasHead := nc.routes[SubscribeURLToNats(HEAD, url)]
+	if hasGet && !hasHead {
+		allowed = append(allowed, HEAD)
+	}
+	if _, ok := nc.routes[SubscribeURLToNats(OPTIONS, url)]; !ok {
+		allowed = append(allowed, OPTIONS)
+	}
+	return allowed
+}
+
+// stripBody removes the body
+// of the response to HEAD request.
+// The Content-Length header is kept.
+func stripBody(c *Context) {
+	c.Response.GetHeader().Set("Content-Length", strconv.Itoa(len(c.Response.Body)))
+	c.Response.Body = c.Response.Body[:0]
+}
+
+// combineHandlers builds the handler
+// chain of the client middleware, route group
+// middleware and route handlers.
+func (nc *NatsClient) combineHandlers(rg *RouterGroup, handlers NatsHandlers) NatsHandlers {
+	merged := make(NatsHandlers, 0, len(nc.filters)+len(handlers))
+	merged = append(merged, nc.filters...)
+	merged = rg.appendMiddleware(merged)
+	return append(merged, handlers...)
+}
+
+func (nc *NatsClient) queueGroupFor(method, url string) string {
+	if nc.queueGroup != "" {
+		return nc.queueGroup
+	}
+	return SubscribeURLToNats(method, url)
 }
 
 // HandleWebsocket subscribes the
 // handler for specific websocketID.
 // The method adds the specific prefix
 // for client to proxy communication.
-func (nc *NatsClient) HandleWebsocket(webSocketID string, handler nats.MsgHandler) {
-	nc.conn.Subscribe(ws_IN+webSocketID, handler)
+// The handler receives the payload of
+// the message, use HandleWebsocketMessage
+// to obtain also the message type.
+func (nc *NatsClient) HandleWebsocket(webSocketID string, handler nats.MsgHandler) error {
+	return nc.subscribeWebsocket(webSocketID, func(m *nats.Msg, msg *WebsocketMessage) {
+		m.Data = msg.Data
+		handler(m)
+	})
+}
+
+// HandleWebsocketMessage subscribes
+// the handler receiving the messages of
+// the websocket with their type,
+// WebsocketText or WebsocketBinary.
+func (nc *NatsClient) HandleWebsocketMessage(webSocketID string, handler WebsocketMessageHandler) error {
+	return nc.subscribeWebsocket(webSocketID, func(m *nats.Msg, msg *WebsocketMessage) {
+		handler(msg)
+	})
+}
+
+func (nc *NatsClient) subscribeWebsocket(webSocketID string, handler func(*nats.Msg, *WebsocketMessage)) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.closed {
+		return ErrNatsClientClosed
+	}
+	if err := nc.watchWebsocket(webSocketID); err != nil {
+		return err
+	}
+	sub, err := nc.conn.Subscribe(ws_IN+webSocketID, func(m *nats.Msg) {
+		msg := decodeWebsocketMessage(m.Data)
+		atomic.AddInt64(&nc.inFlight, 1)
+		defer atomic.AddInt64(&nc.inFlight, -1)
+		defer nc.recoverMsg()
+		handler(m, msg)
+	})
+	if err != nil {
+		return err
+	}
+	nc.wsSubs[webSocketID] = append(nc.wsSubs[webSocketID], sub)
+	return nil
 }
 
 // WriteWebsocketJSON writes struct
@@ -153,40 +577,117 @@ func (nc *NatsClient) WriteWebsocketJSON(websocketID string, msg interface{}) er
 }
 
 // WriteWebsocket writes given bytes
-// to given websocket subject.
+// to given websocket subject
+// as the text message.
 func (nc *NatsClient) WriteWebsocket(websocketID string, data []byte) error {
-	return nc.conn.Publish(ws_OUT+websocketID, data)
+	return nc.WriteWebsocketText(websocketID, data)
+}
+
+// WriteWebsocketText writes given
+// bytes as the text message.
+func (nc *NatsClient) WriteWebsocketText(websocketID string, data []byte) error {
+	return nc.writeWebsocket(websocketID, WebsocketText, data)
+}
+
+// WriteWebsocketBinary writes given
+// bytes as the binary message.
+func (nc *NatsClient) WriteWebsocketBinary(websocketID string, data []byte) error {
+	return nc.writeWebsocket(websocketID, WebsocketBinary, data)
 }
 
-func (nc *NatsClient) SendGET(url string, req *Request) (response *Response, err error) {
-	return nc.Send(GET, url, req)
+func (nc *NatsClient) writeWebsocket(websocketID string, msgType int, data []byte) error {
+	envelope, err := proto.Marshal(&WebsocketMessage{
+		Type: int32(msgType),
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	return nc.conn.Publish(ws_OUT+websocketID, envelope)
 }
 
-func (nc *NatsClient) SendPOST(url string, req *Request) (response *Response, err error) {
-	return nc.Send(POST, url, req)
+// SendGET sends the request
+// to the service subscribed for
+// url with GET method.
+func (nc *NatsClient) SendGET(url string, req *Request, opts ...SendOption) (response *Response, err error) {
+	return nc.Send(GET, url, req, opts...)
 }
 
-func (nc *NatsClient) SendDELETE(url string, req *Request) (response *Response, err error) {
-	return nc.Send(DELETE, url, req)
+// SendPOST sends the request
+// to the service subscribed for
+// url with POST method.
+func (nc *NatsClient) SendPOST(url string, req *Request, opts ...SendOption) (response *Response, err error) {
+	return nc.Send(POST, url, req, opts...)
 }
 
-func (nc *NatsClient) SendPUT(url string, req *Request) (response *Response, err error) {
-	return nc.Send(PUT, url, req)
+// SendDELETE sends the request
+// to the service subscribed for
+// url with DELETE method.
+func (nc *NatsClient) SendDELETE(url string, req *Request, opts ...SendOption) (response *Response, err error) {
+	return nc.Send(DELETE, url, req, opts...)
 }
 
-func (nc *NatsClient) Send(method string, url string, req *Request) (response *Response, err error) {
-	subject := SubscribeURLToNats(method, url)
-	response, err = nc.requestResponse(subject, req)
+// SendPUT sends the request
+// to the service subscribed for
+// url with PUT method.
+func (nc *NatsClient) SendPUT(url string, req *Request, opts ...SendOption) (response *Response, err error) {
+	return nc.Send(PUT, url, req, opts...)
+}
+
+// Send sends the request to
+// the service subscribed for given
+// method and url and waits for the
+// response. The timeout of the client
+// could be overridden by WithTimeout option.
+func (nc *NatsClient) Send(method string, url string, req *Request, opts ...SendOption) (response *Response, err error) {
+	subject := URLToNats(method, url)
+	response, err = nc.requestResponse(subject, req, nc.buildSendOptions(opts))
 	return
 }
 
-func (nc *NatsClient) requestResponse(subj string, req *Request) (res *Response, err error) {
+func (nc *NatsClient) requestResponse(subj string, req *Request, opts *sendOptions) (res *Response, err error) {
 	res = &Response{}
-	data, err := proto.Marshal(req)
+
+	// The deadline of the request
+	// shortens the timeout, the request
+	// without deadline gets the one
+	// derived from timeout.
+	timeout := opts.timeout
+	deadlined := *req
+	if deadline, ok := req.GetDeadline(); ok {
+		if untilDeadline := time.Until(deadline); untilDeadline < timeout {
+			timeout = untilDeadline
+		}
+	} else {
+		deadlined.SetDeadline(time.Now().Add(timeout))
+	}
+	if timeout <= 0 {
+		err = nats.ErrTimeout
+		return
+	}
+
+	// The request continues the trace
+	// of the span given by WithSpan or
+	// of the request itself.
+	parent := req.TraceParent
+	if opts.span != nil {
+		parent = opts.span.Traceparent()
+	}
+	span := startSpan("SEND "+subj, parent, nc.exporter)
+	span.SetAttribute("nats.subject", subj)
+	defer func() {
+		if err != nil {
+			span.SetAttribute("error", err.Error())
+		}
+		span.finish()
+	}()
+	deadlined.TraceParent = span.Traceparent()
+
+	data, err := proto.Marshal(&deadlined)
 	if err != nil {
 		return
 	}
-	msg, err := nc.conn.Request(subj, data, time.Second)
+	msg, err := nc.conn.Request(subj, data, timeout)
 	if err != nil {
 		return
 	}
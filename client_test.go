@@ -3,6 +3,8 @@ package natsproxy
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -92,3 +94,123 @@ func TestDeleteSubscribe(t *testing.T) {
 		t.Error("Did not get response")
 	}
 }
+
+func TestQueueGroupName(t *testing.T) {
+	nc := &NatsClient{}
+	if nc.queueGroupFor(GET, "/users/:id") != "GET:.users.*" {
+		t.Error("Derived queue group assertion failed")
+	}
+	nc.SetQueueGroup("users-service")
+	if nc.queueGroupFor(GET, "/users/:id") != "users-service" {
+		t.Error("Client queue group assertion failed")
+	}
+}
+
+func TestQueueSubscribe(t *testing.T) {
+	var handled int32
+	handler := func(c *Context) {
+		atomic.AddInt32(&handled, 1)
+		c.JSON(200, "OK")
+	}
+
+	// Two replicas of
+	// the same service
+	for i := 0; i < 2; i++ {
+		clientConn, _ := nats.Connect(nats_url)
+		natsClient, _ := NewNatsClient(clientConn)
+		defer clientConn.Close()
+		natsClient.POST("/queue/:id", handler)
+		clientConn.Flush()
+	}
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{})
+
+	requests := 10
+	for i := 0; i < requests; i++ {
+		if _, err := testClient.Request("POST:.queue.1", data, 10*time.Second); err != nil {
+			t.Error("Did not get response")
+		}
+	}
+
+	// Give the possible duplicate
+	// deliveries the time to arrive.
+	time.Sleep(50 * time.Millisecond)
+	if count := atomic.LoadInt32(&handled); count != int32(requests) {
+		t.Errorf("Request handled %d times instead of %d", count, requests)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	nc := &NatsClient{
+		routes:          make(map[string]*Subscription),
+		autoHeadOptions: true,
+	}
+	if len(nc.allowedMethods("/users")) != 0 {
+		t.Error("Unknown url assertion failed")
+	}
+	nc.routes[SubscribeURLToNats(GET, "/users")] = &Subscription{}
+	nc.routes[SubscribeURLToNats(POST, "/users")] = &Subscription{}
+	if allow := strings.Join(nc.allowedMethods("/users"), ", "); allow != "GET, POST, HEAD, OPTIONS" {
+		t.Errorf("Allowed methods assertion failed: %s", allow)
+	}
+
+	nc.autoHeadOptions = false
+	if allow := strings.Join(nc.allowedMethods("/users"), ", "); allow != "GET, POST" {
+		t.Errorf("Allowed methods without auto assertion failed: %s", allow)
+	}
+}
+
+func TestAutoHeadOptions(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.SetHandleMethodNotAllowed(true)
+	defer clientConn.Close()
+	natsClient.GET("/auto/:id", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	natsClient.PATCH("/auto/:id", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{URL: "/auto/1"})
+
+	request := func(method string) *Response {
+		msg, err := testClient.Request(method+":.auto.1", data, time.Second)
+		if err != nil {
+			t.Fatalf("Did not get %s response", method)
+		}
+		res := NewResponse()
+		res.ReadFrom(msg.Data)
+		return res
+	}
+
+	head := request(HEAD)
+	if head.StatusCode != 200 || len(head.Body) != 0 || head.GetHeader().Get("Content-Length") != "4" {
+		t.Error("Automatic HEAD assertion failed")
+	}
+
+	options := request(OPTIONS)
+	if options.StatusCode != 204 || options.GetHeader().Get("Allow") != "GET, PATCH, HEAD, OPTIONS" {
+		t.Error("Automatic OPTIONS assertion failed")
+	}
+
+	notAllowed := request(DELETE)
+	if notAllowed.StatusCode != 405 || notAllowed.GetHeader().Get("Allow") == "" {
+		t.Error("Method not allowed assertion failed")
+	}
+
+	// Explicit route replaces
+	// the automatic one.
+	natsClient.DELETE("/auto/:id", func(c *Context) {
+		c.JSON(200, "deleted")
+	})
+	clientConn.Flush()
+	if res := request(DELETE); res.StatusCode != 200 {
+		t.Error("Explicit route assertion failed")
+	}
+}
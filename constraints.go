@@ -0,0 +1,178 @@
+package natsproxy
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidConstraint is returned if
+	// the constraint of the path variable
+	// is not the valid type or regexp.
+	ErrInvalidConstraint = errors.New("natsproxy: invalid path variable constraint")
+
+	// ErrInvalidUUID is returned
+	// by PathUUID if the path variable
+	// is not the valid UUID.
+	ErrInvalidUUID = errors.New("natsproxy: invalid UUID")
+)
+
+// UUID is the path
+// variable parsed by PathUUID.
+type UUID [16]byte
+
+// String formats the UUID in the
+// canonical lower case form.
+func (u UUID) String() string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+// parseUUID parses the UUID in the
+// canonical form, upper case included.
+func parseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, ErrInvalidUUID
+	}
+	src := []byte(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if _, err := hex.Decode(u[:], src); err != nil {
+		return u, ErrInvalidUUID
+	}
+	return u, nil
+}
+
+// pathConstraint restricts the value
+// of the path variable at the segment index.
+type pathConstraint struct {
+	index  int
+	accept func(value string) bool
+}
+
+type pathConstraints []pathConstraint
+
+// parseParam splits the path variable
+// segment ":name<constraint>" to the
+// name and constraint.
+func parseParam(segment string) (name, constraint string) {
+	name = segment[1:]
+	if i := strings.IndexByte(name, '<'); i >= 0 && strings.HasSuffix(name, ">") {
+		return name[:i], name[i+1 : len(name)-1]
+	}
+	return name, ""
+}
+
+// compileConstraints builds the constraints
+// of the path variables of the url. The
+// "int" and "uuid" constraints are the types,
+// anything else is the regexp the whole
+// value must match.
+func compileConstraints(url string) (pathConstraints, error) {
+	var constraints pathConstraints
+	for i, segment := range pathSegments(url) {
+		if !isParam(segment) {
+			continue
+		}
+		_, constraint := parseParam(segment)
+		if constraint == "" {
+			continue
+		}
+		accept, err := compileConstraint(constraint)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, pathConstraint{i, accept})
+	}
+	return constraints, nil
+}
+
+func compileConstraint(constraint string) (func(string) bool, error) {
+	switch constraint {
+	case "int":
+		return func(value string) bool {
+			_, err := strconv.Atoi(value)
+			return err == nil
+		}, nil
+	case "uuid":
+		return func(value string) bool {
+			_, err := parseUUID(value)
+			return err == nil
+		}, nil
+	}
+	rgxp, err := regexp.Compile("^(?:" + constraint + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidConstraint, err)
+	}
+	return rgxp.MatchString, nil
+}
+
+// acceptSegments checks the constraints
+// against the path segments.
+func (cs pathConstraints) acceptSegments(segments []string) bool {
+	for _, c := range cs {
+		if c.index >= len(segments) || !c.accept(segments[c.index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptTokens checks the constraints
+// against the tokens of the subject.
+func (cs pathConstraints) acceptTokens(tokens []string) bool {
+	for _, c := range cs {
+		if c.index >= len(tokens) {
+			return false
+		}
+		value, err := DecodeSubjectToken(tokens[c.index])
+		if err != nil || !c.accept(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPathConstraintStatus sets the status
+// code of the response to the request whose
+// path variables do not satisfy the constraints
+// of the route. The default is 404 Not Found.
+func (nc *NatsClient) SetPathConstraintStatus(statusCode int) {
+	nc.constraintStatus = statusCode
+}
+
+// rejectPath writes the response to
+// the request violating the constraints.
+func (nc *NatsClient) rejectPath(c *Context) {
+	status := nc.constraintStatus
+	if status == 0 {
+		status = http.StatusNotFound
+	}
+	c.Response.StatusCode = int32(status)
+	c.Response.Body = []byte(http.StatusText(status))
+}
+
+// PathInt returns the path
+// variable parsed as int.
+func (c *Context) PathInt(name string) (int, error) {
+	return strconv.Atoi(c.PathVariable(name))
+}
+
+// PathUUID returns the path
+// variable parsed as UUID.
+func (c *Context) PathUUID(name string) (UUID, error) {
+	return parseUUID(c.PathVariable(name))
+}
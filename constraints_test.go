@@ -0,0 +1,122 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats"
+)
+
+func TestParseParam(t *testing.T) {
+	cases := map[string][2]string{
+		":id":              {"id", ""},
+		":id<int>":         {"id", "int"},
+		":slug<[a-z-]+>":   {"slug", "[a-z-]+"},
+		":code<[0-9]{2}>":  {"code", "[0-9]{2}"},
+		":broken<int":      {"broken<int", ""},
+		":arrow<a>b|[>]+>": {"arrow", "a>b|[>]+"},
+	}
+	for segment, expected := range cases {
+		name, constraint := parseParam(segment)
+		if name != expected[0] || constraint != expected[1] {
+			t.Errorf("Parsing of %s assertion failed: %s %s", segment, name, constraint)
+		}
+	}
+	if m := buildParamMap("/users/:id<int>/posts/:slug<[a-z-]+>"); m["id"] != 2 || m["slug"] != 4 {
+		t.Errorf("Param map assertion failed: %v", m)
+	}
+}
+
+func TestCompileConstraints(t *testing.T) {
+	constraints, err := compileConstraints("/users/:id<int>/posts/:slug<[a-z-]+>/:any")
+	if err != nil || len(constraints) != 2 {
+		t.Fatalf("Constraints assertion failed: %v", err)
+	}
+	cases := map[string]bool{
+		"/users/42/posts/hello-world/x": true,
+		"/users/-1/posts/a/x":           true,
+		"/users/abc/posts/hello/x":      false,
+		"/users/42/posts/Hello/x":       false,
+		"/users/42/posts/hello-1/x":     false,
+	}
+	for path, accepted := range cases {
+		if constraints.acceptSegments(pathSegments(path)) != accepted {
+			t.Errorf("Constraints of %s assertion failed", path)
+		}
+	}
+	if _, err := compileConstraints("/users/:id<[a-z>"); err == nil {
+		t.Error("Invalid constraint assertion failed")
+	}
+}
+
+func TestPathTypedVariables(t *testing.T) {
+	req := &Request{URL: "/orders/42/6BA7B810-9DAD-11D1-80B4-00C04FD430C8"}
+	c := newContext(buildParamMap("/orders/:id<int>/:ref<uuid>"), &Response{}, req)
+	if id, err := c.PathInt("id"); err != nil || id != 42 {
+		t.Error("PathInt assertion failed")
+	}
+	if ref, err := c.PathUUID("ref"); err != nil || ref.String() != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("PathUUID assertion failed: %s %v", ref, err)
+	}
+	if _, err := c.PathInt("ref"); err == nil {
+		t.Error("Invalid int assertion failed")
+	}
+	if _, err := c.PathUUID("id"); err != ErrInvalidUUID {
+		t.Error("Invalid UUID assertion failed")
+	}
+}
+
+func TestPathConstraints(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.GET("/constrained/:id<int>", func(c *Context) {
+		c.Response.Body = []byte("id")
+	})
+	natsClient.GET("/constrained/*rest", func(c *Context) {
+		c.Response.Body = []byte("rest")
+	})
+	natsClient.GET("/uuid/:ref<uuid>", func(c *Context) {
+		c.Response.Body = []byte("uuid")
+	})
+	natsClient.GET("/slug/:slug<[a-z-]+>", func(c *Context) {
+		c.Response.Body = []byte("slug")
+	})
+	if _, err := natsClient.GET("/invalid/:slug<[a-z>", func(c *Context) {}); err == nil {
+		t.Error("Invalid constraint route assertion failed")
+	}
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:3000"+path, nil)
+		rw := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rw, req)
+		return rw
+	}
+	cases := map[string]string{
+		"/constrained/42":  "id",
+		"/constrained/abc": "rest",
+		"/uuid/6ba7b810-9dad-11d1-80b4-00c04fd430c8": "uuid",
+		"/slug/hello-world":                          "slug",
+	}
+	for path, expected := range cases {
+		if rw := get(path); rw.Code != http.StatusOK || rw.Body.String() != expected {
+			t.Errorf("Route of %s assertion failed: %d %q", path, rw.Code, rw.Body.String())
+		}
+	}
+	for _, path := range []string{"/uuid/123", "/slug/Hello"} {
+		if rw := get(path); rw.Code != http.StatusNotFound {
+			t.Errorf("Rejected %s assertion failed: %d", path, rw.Code)
+		}
+	}
+
+	natsClient.SetPathConstraintStatus(http.StatusBadRequest)
+	if rw := get("/uuid/123"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Constraint status assertion failed: %d", rw.Code)
+	}
+}
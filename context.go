@@ -2,19 +2,30 @@ package natsproxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"math"
 	"mime"
 
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats"
 )
 
 var removeQueryRxp = regexp.MustCompile("[?]{1}.*")
 
+// abortIndex is the index
+// of handler chain that marks the
+// context as aborted.
+const abortIndex = math.MaxInt32 / 2
+
 // Context wraps the
 // processed request/response
 type Context struct {
@@ -22,8 +33,65 @@ type Context struct {
 	Response    *Response
 	RequestForm url.Values
 	index       int
-	abortIndex  int
+	handlers    NatsHandlers
 	params      map[string]int
+	ctx         context.Context
+	cancel      context.CancelFunc
+	conn        *nats.Conn
+	reply       string
+	bodyReader  *bodyReader
+	bodyWriter  *bodyWriter
+	replied     bool
+	route       string
+	span        *Span
+}
+
+// Ctx returns the context.Context
+// of the request. The context is
+// cancelled at the deadline of the request
+// set by the proxy or when the processing
+// of the request is finished.
+func (c *Context) Ctx() context.Context {
+	if c.ctx == nil {
+		if deadline, ok := c.Request.GetDeadline(); ok {
+			c.ctx, c.cancel = context.WithDeadline(context.Background(), deadline)
+		} else {
+			c.ctx, c.cancel = context.WithCancel(context.Background())
+		}
+	}
+	return c.ctx
+}
+
+// IsExpired returns true if the
+// deadline of the request passed
+// and the requester does not wait
+// for the response anymore.
+func (c *Context) IsExpired() bool {
+	deadline, ok := c.Request.GetDeadline()
+	return ok && time.Now().After(deadline)
+}
+
+// release cancels the context.Context
+// of the request if it was created.
+func (c *Context) release() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Next executes the pending
+// handlers in the chain. It should
+// be used only inside middleware, the
+// code after Next is executed after
+// the handlers down the chain returned.
+// Middleware not calling Next is followed
+// by the next handler automatically.
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
 }
 
 // IsAborted returns true
@@ -31,14 +99,24 @@ type Context struct {
 // were aborted by previous
 // middleware
 func (c *Context) IsAborted() bool {
-	return c.index >= c.abortIndex
+	return c.index >= abortIndex
 }
 
 // Abort abortsthe
 // request that it won's be
-// processed further
+// processed further. The pending
+// handlers are not called, the current
+// handler and the middleware code after
+// Next are still executed.
 func (c *Context) Abort() {
-	c.abortIndex = c.index
+	c.index = abortIndex
+}
+
+// AbortWithStatus aborts the request
+// and sets the HTTP status code.
+func (c *Context) AbortWithStatus(statusCode int) {
+	c.Abort()
+	c.Response.StatusCode = int32(statusCode)
 }
 
 // AbortWithJSON aborts the request
@@ -52,6 +130,9 @@ func (c *Context) AbortWithJSON(obj interface{}) {
 // request body to given
 // struct
 func (c *Context) BindJSON(obj interface{}) error {
+	if err := c.loadBody(); err != nil {
+		return err
+	}
 	if err := json.Unmarshal(c.Request.Body, obj); err != nil {
 		return err
 	}
@@ -72,20 +153,29 @@ func (c *Context) JSON(statusCode int, obj interface{}) {
 // PathVariable returns
 // the path variable
 // based on its name (:xxx) defined
-// in subscription URL
+// in subscription URL. The catch-all
+// variable (*xxx) is the rest of the
+// path joined by "/".
 func (c *Context) PathVariable(name string) string {
 	RawURL, err := url.Parse(c.Request.URL)
 	if err != nil {
 		return ""
 	}
 	URL := removeQueryRxp.ReplaceAllString(RawURL.Path, "")
-	pathParams := strings.Split(URL, "/")
+	pathParams := pathSegments(URL)
 
 	index, ok := c.params[name]
-	if !ok || len(pathParams) <= index {
-		return ""
+	if ok && len(pathParams) > index {
+		return pathParams[index]
+	}
+
+	// The catch-all variable
+	// is the joined remainder.
+	index, ok = c.params["*"+name]
+	if ok && len(pathParams) > index {
+		return strings.Join(pathParams[index:], "/")
 	}
-	return pathParams[index]
+	return ""
 }
 
 // FormVariable returns the
@@ -124,6 +214,10 @@ func (c *Context) ParseForm() error {
 	var err error
 	r := c.Request
 
+	if err = c.loadBody(); err != nil {
+		return err
+	}
+
 	RawURL, err := url.Parse(r.URL)
 	if err != nil {
 		return err
@@ -224,22 +318,38 @@ func (c *Context) writeError(err error) {
 
 func newContext(paramMap map[string]int, res *Response, req *Request) *Context {
 	return &Context{
-		req,
-		res,
-		nil,
-		0,
-		1<<31 - 1,
-		paramMap,
+		Request:  req,
+		Response: res,
+		index:    -1,
+		params:   paramMap,
 	}
 }
 
+// buildParamMap maps the names of path
+// variables to the segment indexes. The
+// catch-all variable is stored with
+// the "*" prefix.
 func buildParamMap(url string) map[string]int {
 	m := make(map[string]int)
-	prmArr := strings.Split(url, "/")
+	prmArr := pathSegments(url)
 	for i, prm := range prmArr {
 		if len(prm) > 0 && prm[:1] == ":" {
-			m[prm[1:]] = i
+			name, _ := parseParam(prm)
+			m[name] = i
+		} else if isCatchAll(prm) {
+			m[prm] = i
 		}
 	}
 	return m
 }
+
+// publishResponse sends the response
+// to the proxy before the handler chain
+// finishes.
+func (c *Context) publishResponse() error {
+	data, err := proto.Marshal(c.Response)
+	if err != nil {
+		return err
+	}
+	return c.conn.Publish(c.reply, data)
+}
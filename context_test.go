@@ -1,11 +1,13 @@
 package natsproxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPathVariable(t *testing.T) {
@@ -45,6 +47,24 @@ func TestPathVariable(t *testing.T) {
 
 }
 
+func TestCatchAllPathVariable(t *testing.T) {
+	req := &Request{
+		URL: "/static/1/css/site.v2.css?v=1",
+	}
+	ctx := newContext(buildParamMap("/static/:version/*filepath"), &Response{}, req)
+	if ctx.PathVariable("version") != "1" {
+		t.Error("Path variable assertion failed")
+	}
+	if ctx.PathVariable("filepath") != "css/site.v2.css" {
+		t.Errorf("Catch-all path variable assertion failed: %s", ctx.PathVariable("filepath"))
+	}
+
+	req.URL = "/static/1/dir/"
+	if ctx.PathVariable("filepath") != "dir/" {
+		t.Error("Trailing slash of catch-all assertion failed")
+	}
+}
+
 func TestParseForm(t *testing.T) {
 	url := "http://127.0.0.1:3000/test/12324/123?name=queryname"
 	reader := strings.NewReader("z=post&both=y&prio=2&empty=&name=postname")
@@ -130,3 +150,95 @@ func TestBindJson(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestHandlerChain(t *testing.T) {
+	trace := make([]string, 0)
+	ctx := newContext(buildParamMap("/test"), NewResponse(), &Request{URL: "/test"})
+	ctx.handlers = NatsHandlers{
+		func(c *Context) {
+			trace = append(trace, "timing-start")
+			c.Next()
+			trace = append(trace, "timing-end")
+		},
+		func(c *Context) {
+			trace = append(trace, "plain")
+		},
+		func(c *Context) {
+			trace = append(trace, "handler")
+			c.Response.StatusCode = 201
+		},
+	}
+	ctx.Next()
+
+	expected := "timing-start,plain,handler,timing-end"
+	if strings.Join(trace, ",") != expected {
+		t.Errorf("Chain order assertion failed: %v", trace)
+	}
+}
+
+func TestHandlerChainAbort(t *testing.T) {
+	handlerCalled := false
+	postHandlerCalled := false
+	ctx := newContext(buildParamMap("/test"), NewResponse(), &Request{URL: "/test"})
+	ctx.handlers = NatsHandlers{
+		func(c *Context) {
+			c.Next()
+			postHandlerCalled = true
+			if !c.IsAborted() {
+				t.Error("Abort propagation assertion failed")
+			}
+		},
+		func(c *Context) {
+			c.AbortWithStatus(401)
+		},
+		func(c *Context) {
+			handlerCalled = true
+		},
+	}
+	ctx.Next()
+
+	if handlerCalled {
+		t.Error("Handler called after abort")
+	}
+	if !postHandlerCalled {
+		t.Error("Middleware code after Next not called")
+	}
+	if ctx.Response.StatusCode != 401 {
+		t.Error("Abort status assertion failed")
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	req := &Request{URL: "/test"}
+	req.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	ctx := newContext(buildParamMap("/test"), NewResponse(), req)
+	defer ctx.release()
+
+	deadline, ok := ctx.Ctx().Deadline()
+	if !ok || deadline.UnixNano() != req.Deadline {
+		t.Error("Context deadline assertion failed")
+	}
+	if ctx.IsExpired() {
+		t.Error("Expired assertion failed")
+	}
+
+	select {
+	case <-ctx.Ctx().Done():
+	case <-time.After(time.Second):
+		t.Error("Context not cancelled at deadline")
+	}
+	if !ctx.IsExpired() {
+		t.Error("Expired after deadline assertion failed")
+	}
+}
+
+func TestContextWithoutDeadline(t *testing.T) {
+	ctx := newContext(buildParamMap("/test"), NewResponse(), &Request{URL: "/test"})
+	if _, ok := ctx.Ctx().Deadline(); ok {
+		t.Error("Deadline without request deadline")
+	}
+	ctx.release()
+	if ctx.Ctx().Err() != context.Canceled {
+		t.Error("Context not cancelled on release")
+	}
+}
@@ -0,0 +1,155 @@
+package natsproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// DefaultRetryAfter is the delay
+// advertised in Retry-After header
+// if the NATS connection is not available.
+const DefaultRetryAfter = 5 * time.Second
+
+// noRespondersMsg is the message of
+// the error returned by NATS servers supporting
+// headers if there is no subscriber for the subject.
+const noRespondersMsg = "nats: no responders available for request"
+
+// ProxyError describes the failure
+// of the proxy to obtain the response
+// from the service. StatusCode is the HTTP
+// status the failure is mapped to.
+type ProxyError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProxyError) Error() string {
+	if e.Err != nil {
+		return "nats-proxy: " + e.Message + ": " + e.Err.Error()
+	}
+	return "nats-proxy: " + e.Message
+}
+
+// ErrorHandler renders the ProxyError
+// to the HTTP response. It could be set by
+// NatsProxy.SetErrorHandler to render errors
+// consistent with the rest of the API.
+type ErrorHandler func(rw http.ResponseWriter, req *http.Request, err *ProxyError)
+
+// DefaultErrorHandler writes the
+// plain text error message with
+// the status code of the error.
+func DefaultErrorHandler(rw http.ResponseWriter, req *http.Request, err *ProxyError) {
+	writeRetryAfter(rw, err)
+	http.Error(rw, err.Message, err.StatusCode)
+}
+
+// ProblemJSONErrorHandler writes the
+// error as application/problem+json
+// body defined by RFC 7807.
+func ProblemJSONErrorHandler(rw http.ResponseWriter, req *http.Request, err *ProxyError) {
+	problem := struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail,omitempty"`
+	}{
+		"about:blank",
+		http.StatusText(err.StatusCode),
+		err.StatusCode,
+		err.Message,
+	}
+	writeRetryAfter(rw, err)
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(err.StatusCode)
+	json.NewEncoder(rw).Encode(problem)
+}
+
+func writeRetryAfter(rw http.ResponseWriter, err *ProxyError) {
+	if err.RetryAfter > 0 {
+		secs := int(err.RetryAfter / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		rw.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+}
+
+// SetErrorHandler sets the handler
+// rendering the errors of the proxy.
+func (np *NatsProxy) SetErrorHandler(handler ErrorHandler) {
+	np.errorHandler = handler
+}
+
+func (np *NatsProxy) handleError(rw http.ResponseWriter, req *http.Request, err *ProxyError) {
+	handler := np.errorHandler
+	if handler == nil {
+		handler = DefaultErrorHandler
+	}
+	handler(rw, req, err)
+}
+
+func newInternalError(err error) *ProxyError {
+	return &ProxyError{
+		StatusCode: http.StatusInternalServerError,
+		Message:    "Cannot process request",
+		Err:        err,
+	}
+}
+
+func newBadGatewayError(err error) *ProxyError {
+	return &ProxyError{
+		StatusCode: http.StatusBadGateway,
+		Message:    "Cannot deserialize response",
+		Err:        err,
+	}
+}
+
+func newForbiddenOriginError() *ProxyError {
+	return &ProxyError{
+		StatusCode: http.StatusForbidden,
+		Message:    "Origin not allowed",
+	}
+}
+
+// newRequestError maps the error
+// of NATS request to the ProxyError.
+func newRequestError(conn *nats.Conn, err error) *ProxyError {
+	switch {
+	case err == nats.ErrTimeout:
+		return &ProxyError{
+			StatusCode: http.StatusGatewayTimeout,
+			Message:    "No response",
+			Err:        err,
+		}
+	case err.Error() == noRespondersMsg:
+		return &ProxyError{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "No service available",
+			Err:        err,
+		}
+	case err == nats.ErrConnectionClosed,
+		err == nats.ErrConnectionDraining,
+		err == nats.ErrNoServers,
+		err == nats.ErrReconnectBufExceeded,
+		conn != nil && !conn.IsConnected():
+		return &ProxyError{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Service connection not available",
+			RetryAfter: DefaultRetryAfter,
+			Err:        err,
+		}
+	}
+	return &ProxyError{
+		StatusCode: http.StatusBadGateway,
+		Message:    "No response",
+		Err:        err,
+	}
+}
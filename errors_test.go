@@ -0,0 +1,62 @@
+package natsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nats-io/nats"
+)
+
+func TestRequestErrorMapping(t *testing.T) {
+	if err := newRequestError(nil, nats.ErrTimeout); err.StatusCode != http.StatusGatewayTimeout {
+		t.Error("Timeout mapping assertion failed")
+	}
+
+	if err := newRequestError(nil, errors.New(noRespondersMsg)); err.StatusCode != http.StatusServiceUnavailable {
+		t.Error("No responders mapping assertion failed")
+	}
+
+	err := newRequestError(nil, nats.ErrConnectionClosed)
+	if err.StatusCode != http.StatusServiceUnavailable || err.RetryAfter == 0 {
+		t.Error("Closed connection mapping assertion failed")
+	}
+
+	if err := newBadGatewayError(errors.New("malformed")); err.StatusCode != http.StatusBadGateway {
+		t.Error("Bad gateway mapping assertion failed")
+	}
+}
+
+func TestDefaultErrorHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	DefaultErrorHandler(rw, nil, newRequestError(nil, nats.ErrConnectionClosed))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Error("Status code assertion failed")
+	}
+	if rw.Header().Get("Retry-After") != "5" {
+		t.Error("Retry-After assertion failed")
+	}
+}
+
+func TestProblemJSONErrorHandler(t *testing.T) {
+	rw := httptest.NewRecorder()
+	ProblemJSONErrorHandler(rw, nil, newRequestError(nil, nats.ErrTimeout))
+	if rw.Code != http.StatusGatewayTimeout {
+		t.Error("Status code assertion failed")
+	}
+	if rw.Header().Get("Content-Type") != "application/problem+json" {
+		t.Error("Content type assertion failed")
+	}
+	problem := struct {
+		Status int
+		Title  string
+	}{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &problem); err != nil {
+		t.Error(err)
+	}
+	if problem.Status != http.StatusGatewayTimeout || problem.Title != "Gateway Timeout" {
+		t.Error("Problem body assertion failed")
+	}
+}
@@ -0,0 +1,142 @@
+package natsproxy
+
+import "path"
+
+// RouterGroup groups the routes
+// of NatsClient under the common
+// url prefix and middleware.
+// The middleware of the group is applied
+// only to routes subscribed by the group
+// or its subgroups.
+type RouterGroup struct {
+	client  *NatsClient
+	parent  *RouterGroup
+	prefix  string
+	filters NatsHandlers
+}
+
+// Group creates the route group
+// with given url prefix and middleware.
+func (nc *NatsClient) Group(prefix string, middleware ...NatsHandler) *RouterGroup {
+	return &RouterGroup{
+		client:  nc,
+		prefix:  prefix,
+		filters: append(NatsHandlers{}, middleware...),
+	}
+}
+
+// Group creates the subgroup with
+// the prefix joined to the prefix of
+// the group. The subgroup inherits
+// the middleware of the group.
+func (rg *RouterGroup) Group(prefix string, middleware ...NatsHandler) *RouterGroup {
+	return &RouterGroup{
+		client:  rg.client,
+		parent:  rg,
+		prefix:  joinPaths(rg.prefix, prefix),
+		filters: append(NatsHandlers{}, middleware...),
+	}
+}
+
+// Prefix returns the url
+// prefix of the group.
+func (rg *RouterGroup) Prefix() string {
+	return rg.prefix
+}
+
+// Use adds the middleware
+// to the group.
+func (rg *RouterGroup) Use(middleware ...NatsHandler) {
+	rg.filters = append(rg.filters, middleware...)
+}
+
+// GET subscribes the group
+// for an url with GET method.
+func (rg *RouterGroup) GET(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(GET, url, handlers...)
+}
+
+// POST subscribes the group
+// for an url with POST method.
+func (rg *RouterGroup) POST(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(POST, url, handlers...)
+}
+
+// PUT subscribes the group
+// for an url with PUT method.
+func (rg *RouterGroup) PUT(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(PUT, url, handlers...)
+}
+
+// DELETE subscribes the group
+// for an url with DELETE method.
+func (rg *RouterGroup) DELETE(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(DELETE, url, handlers...)
+}
+
+// PATCH subscribes the group
+// for an url with PATCH method.
+func (rg *RouterGroup) PATCH(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(PATCH, url, handlers...)
+}
+
+// HEAD subscribes the group
+// for an url with HEAD method.
+func (rg *RouterGroup) HEAD(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(HEAD, url, handlers...)
+}
+
+// OPTIONS subscribes the group
+// for an url with OPTIONS method.
+func (rg *RouterGroup) OPTIONS(url string, handlers ...NatsHandler) (*Subscription, error) {
+	return rg.Subscribe(OPTIONS, url, handlers...)
+}
+
+// Any subscribes the group for
+// an url with GET, POST, PUT, PATCH,
+// DELETE, HEAD and OPTIONS method.
+func (rg *RouterGroup) Any(url string, handlers ...NatsHandler) ([]*Subscription, error) {
+	subs := make([]*Subscription, 0, len(anyMethods))
+	for _, method := range anyMethods {
+		s, err := rg.Subscribe(method, url, handlers...)
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+// Subscribe subscribes the handlers
+// for the method and url prefixed
+// by the group prefix.
+func (rg *RouterGroup) Subscribe(method, url string, handlers ...NatsHandler) (*Subscription, error) {
+	fullURL := joinPaths(rg.prefix, url)
+	group := rg.client.queueGroupFor(method, fullURL)
+	return rg.client.subscribe(method, fullURL, group, rg, handlers)
+}
+
+// appendMiddleware appends the middleware
+// of the group and its parents, the
+// outermost group first.
+func (rg *RouterGroup) appendMiddleware(handlers NatsHandlers) NatsHandlers {
+	if rg == nil {
+		return handlers
+	}
+	handlers = rg.parent.appendMiddleware(handlers)
+	return append(handlers, rg.filters...)
+}
+
+// joinPaths joins the url prefix
+// and relative url. The trailing slash
+// of relative url is kept.
+func joinPaths(prefix, relative string) string {
+	if relative == "" {
+		return prefix
+	}
+	joined := path.Join(prefix, relative)
+	if relative[len(relative)-1] == '/' && joined[len(joined)-1] != '/' {
+		return joined + "/"
+	}
+	return joined
+}
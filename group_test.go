@@ -0,0 +1,90 @@
+package natsproxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats"
+)
+
+func TestJoinPaths(t *testing.T) {
+	cases := map[[2]string]string{
+		{"/api/v1", "/users/:id"}: "/api/v1/users/:id",
+		{"/api/v1/", "users"}:     "/api/v1/users",
+		{"/api/v1", ""}:           "/api/v1",
+		{"/api", "/files/"}:       "/api/files/",
+	}
+	for in, expected := range cases {
+		if out := joinPaths(in[0], in[1]); out != expected {
+			t.Errorf("Join of %v assertion failed: %s", in, out)
+		}
+	}
+}
+
+func TestGroupMiddleware(t *testing.T) {
+	trace := make([]string, 0)
+	tracer := func(name string) NatsHandler {
+		return func(c *Context) {
+			trace = append(trace, name)
+		}
+	}
+	nc := &NatsClient{}
+	nc.Use(tracer("client"))
+	api := nc.Group("/api", tracer("api"))
+	v1 := api.Group("/v1")
+	v1.Use(tracer("v1"))
+
+	if v1.Prefix() != "/api/v1" {
+		t.Error("Group prefix assertion failed")
+	}
+
+	ctx := newContext(buildParamMap("/test"), NewResponse(), &Request{})
+	ctx.handlers = nc.combineHandlers(v1, NatsHandlers{tracer("handler")})
+	ctx.Next()
+	if strings.Join(trace, ",") != "client,api,v1,handler" {
+		t.Errorf("Group chain assertion failed: %v", trace)
+	}
+
+	// Middleware of group
+	// is not applied outside.
+	trace = trace[:0]
+	ctx = newContext(buildParamMap("/test"), NewResponse(), &Request{})
+	ctx.handlers = nc.combineHandlers(nil, NatsHandlers{tracer("handler")})
+	ctx.Next()
+	if strings.Join(trace, ",") != "client,handler" {
+		t.Errorf("Client chain assertion failed: %v", trace)
+	}
+}
+
+func TestGroupSubscribe(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	natsClient, _ := NewNatsClient(clientConn)
+	defer clientConn.Close()
+
+	v1 := natsClient.Group("/api/v1", func(c *Context) {
+		c.Response.GetHeader().Set("X-Version", "v1")
+	})
+	v1.GET("/users/:id", func(c *Context) {
+		c.JSON(200, c.PathVariable("id"))
+	})
+	clientConn.Flush()
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{URL: "/api/v1/users/42"})
+
+	msg, err := testClient.Request("GET:.api.v1.users.42", data, 10*time.Second)
+	if err != nil {
+		t.Fatal("Did not get response")
+	}
+	res := NewResponse()
+	res.ReadFrom(msg.Data)
+	if string(res.Body) != `"42"` {
+		t.Error("Group path variable assertion failed")
+	}
+	if res.GetHeader().Get("X-Version") != "v1" {
+		t.Error("Group middleware assertion failed")
+	}
+}
@@ -0,0 +1,77 @@
+package natsproxy
+
+import (
+	"log/slog"
+)
+
+// Logger is the leveled logger of the
+// proxy and client. The keysAndValues
+// are the alternating keys and values
+// of the structured log event.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NewSlogLogger adapts the slog.Logger
+// to the Logger. The nil logger is
+// replaced by the slog.Default.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger.With("component", "nats-proxy")}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Info(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warn(msg, keysAndValues...)
+}
+
+func (l *slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, keysAndValues...)
+}
+
+// NopLogger discards all log
+// events. It is the default
+// logger of the proxy and client.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (NopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (NopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (NopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// SetLogger sets the logger
+// of the proxy. The nil logger,
+// the default, discards the log events.
+func (np *NatsProxy) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	np.logger = logger
+	np.rooms.logger = logger
+}
+
+// SetLogger sets the logger
+// of the client. The nil logger,
+// the default, discards the log events.
+func (nc *NatsClient) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	nc.logger = logger
+}
@@ -0,0 +1,139 @@
+package natsproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats"
+)
+
+// recordingLogger records
+// the logged events.
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []loggedEvent
+}
+
+type loggedEvent struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+func (l *recordingLogger) log(level, msg string, keysAndValues []interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	l.mu.Lock()
+	l.events = append(l.events, loggedEvent{level, msg, fields})
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) Debug(msg string, kv ...interface{}) { l.log("debug", msg, kv) }
+func (l *recordingLogger) Info(msg string, kv ...interface{})  { l.log("info", msg, kv) }
+func (l *recordingLogger) Warn(msg string, kv ...interface{})  { l.log("warn", msg, kv) }
+func (l *recordingLogger) Error(msg string, kv ...interface{}) { l.log("error", msg, kv) }
+
+func (l *recordingLogger) snapshot() []loggedEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]loggedEvent(nil), l.events...)
+}
+
+func TestProxyLogger(t *testing.T) {
+	// The service replies
+	// with invalid response.
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	clientConn.Subscribe(URLToNats("GET", "/logger/invalid"), func(m *nats.Msg) {
+		clientConn.Publish(m.Reply, []byte{0xff, 0xff})
+	})
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	logger := &recordingLogger{}
+	proxyHandler.SetLogger(logger)
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/logger/invalid", nil)
+	req.Header.Set(RequestIDHeader, "logged")
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadGateway {
+		t.Errorf("Status assertion failed: %d", rw.Code)
+	}
+
+	events := logger.snapshot()
+	if len(events) != 1 {
+		t.Fatalf("Logged events assertion failed: %v", events)
+	}
+	if events[0].level != "error" || events[0].fields["request_id"] != "logged" || events[0].fields["error"] == nil {
+		t.Errorf("Logged event assertion failed: %v", events[0])
+	}
+}
+
+func TestClientLoggerPanic(t *testing.T) {
+	logger := &recordingLogger{}
+	nc := &NatsClient{}
+	nc.SetLogger(logger)
+
+	c := newContext(buildParamMap("/test"), NewResponse(), &Request{RequestID: "panicked"})
+	c.handlers = NatsHandlers{func(c *Context) { panic("boom") }}
+	nc.runChain(c)
+
+	events := logger.snapshot()
+	if len(events) != 1 || events[0].fields["panic"] != "boom" || events[0].fields["request_id"] != "panicked" {
+		t.Errorf("Logged panic assertion failed: %v", events)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+	logger.Warn("stream failed", "request_id", "abc")
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["level"] != "WARN" || event["msg"] != "stream failed" || event["request_id"] != "abc" || event["component"] != "nats-proxy" {
+		t.Errorf("Slog event assertion failed: %v", event)
+	}
+
+	// Debug is below
+	// the default level.
+	buf.Reset()
+	logger.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Error("Level assertion failed")
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	conn, _ := nats.Connect(nats_url)
+	defer conn.Close()
+	np, _ := NewNatsProxy(conn)
+	nc, _ := NewNatsClient(conn)
+	if _, ok := np.logger.(NopLogger); !ok {
+		t.Error("Proxy default logger assertion failed")
+	}
+	if _, ok := nc.logger.(NopLogger); !ok {
+		t.Error("Client default logger assertion failed")
+	}
+}
+
+func TestSetNilLogger(t *testing.T) {
+	nc := &NatsClient{}
+	nc.SetLogger(nil)
+	if _, ok := nc.logger.(NopLogger); !ok {
+		t.Error("Nop logger assertion failed")
+	}
+	nc.logger.Error("discarded", "key", "value")
+}
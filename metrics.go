@@ -0,0 +1,326 @@
+package natsproxy
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otherRoute labels the requests
+// not matching any route added
+// by Metrics.AddRoute and the
+// unknown methods.
+const otherRoute = "other"
+
+// DefaultBuckets are the upper bounds
+// of the latency histograms in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the metrics of NatsProxy
+// and NatsClient and serves them in Prometheus
+// text format. The same Metrics could be set
+// to both the proxy and the client.
+type Metrics struct {
+	mu       sync.Mutex
+	routes   [][]string
+	families []*metricFamily
+
+	requests         *metricFamily
+	roundTripLatency *metricFamily
+	inFlight         *metricFamily
+	timeouts         *metricFamily
+	decodeErrors     *metricFamily
+	websockets       *metricFamily
+	wsMessages       *metricFamily
+	handlerLatency   *metricFamily
+	panics           *metricFamily
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+// NewMetrics creates the
+// metrics with all series empty.
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.requests = m.family("natsproxy_requests_total", "HTTP requests served by the proxy.", "counter", "route", "method", "status")
+	m.roundTripLatency = m.family("natsproxy_request_duration_seconds", "NATS round-trip of the proxied requests.", "histogram", "route", "method")
+	m.inFlight = m.family("natsproxy_requests_in_flight", "Requests being proxied.", "gauge")
+	m.timeouts = m.family("natsproxy_request_timeouts_total", "Requests without response in time.", "counter", "route", "method")
+	m.decodeErrors = m.family("natsproxy_deserialization_errors_total", "Responses which could not be deserialized.", "counter")
+	m.websockets = m.family("natsproxy_websockets_active", "Open websockets.", "gauge")
+	m.wsMessages = m.family("natsproxy_websocket_messages_total", "Websocket messages by direction.", "counter", "direction")
+	m.handlerLatency = m.family("natsproxy_client_handler_duration_seconds", "Duration of the client handler chain.", "histogram", "route", "method")
+	m.panics = m.family("natsproxy_client_panics_total", "Panics recovered in the client handlers.", "counter", "route", "method")
+	m.inFlight.add(0)
+	m.decodeErrors.add(0)
+	m.websockets.add(0)
+	return m
+}
+
+func (m *Metrics) family(name, help, kind string, labels ...string) *metricFamily {
+	f := &metricFamily{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+	if kind == "histogram" {
+		f.buckets = DefaultBuckets
+	}
+	m.families = append(m.families, f)
+	return f
+}
+
+// AddRoute registers the route template
+// like "/users/:id" used as the route label
+// of the proxied requests. The requests not
+// matching any route are labeled "other".
+func (m *Metrics) AddRoute(url string) {
+	m.mu.Lock()
+	m.routes = append(m.routes, strings.Split(url, "/"))
+	m.mu.Unlock()
+}
+
+// route returns the first
+// route matching the path.
+func (m *Metrics) route(path string) string {
+	if m == nil {
+		return ""
+	}
+	segments := strings.Split(path, "/")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, route := range m.routes {
+		if matchSegments(route, segments) {
+			return strings.Join(route, "/")
+		}
+	}
+	return otherRoute
+}
+
+// methodLabel returns the method
+// subscribed by Any helper, any other
+// method is labeled "other".
+func methodLabel(method string) string {
+	for _, m := range anyMethods {
+		if method == m {
+			return method
+		}
+	}
+	return otherRoute
+}
+
+func matchSegments(route, segments []string) bool {
+	if n := len(route); n > 0 && isCatchAll(route[n-1]) {
+		if len(segments) < n {
+			return false
+		}
+		segments = segments[:n]
+	} else if len(route) != len(segments) {
+		return false
+	}
+	for i, s := range route {
+		if isCatchAll(s) {
+			continue
+		}
+		if strings.HasPrefix(s, ":") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Metrics) add(f *metricFamily, v float64, labelValues ...string) {
+	m.mu.Lock()
+	f.add(v, labelValues...)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) observe(f *metricFamily, d time.Duration, labelValues ...string) {
+	m.mu.Lock()
+	f.observe(d.Seconds(), labelValues...)
+	m.mu.Unlock()
+}
+
+// The recording methods
+// are no-op on nil Metrics.
+
+func (m *Metrics) requestStarted() {
+	if m != nil {
+		m.add(m.inFlight, 1)
+	}
+}
+
+func (m *Metrics) requestFinished(route, method string, status int) {
+	if m != nil {
+		m.add(m.inFlight, -1)
+		m.add(m.requests, 1, route, methodLabel(method), strconv.Itoa(status))
+	}
+}
+
+func (m *Metrics) roundTrip(route, method string, d time.Duration) {
+	if m != nil {
+		m.observe(m.roundTripLatency, d, route, methodLabel(method))
+	}
+}
+
+func (m *Metrics) timeout(route, method string) {
+	if m != nil {
+		m.add(m.timeouts, 1, route, methodLabel(method))
+	}
+}
+
+func (m *Metrics) decodeError() {
+	if m != nil {
+		m.add(m.decodeErrors, 1)
+	}
+}
+
+func (m *Metrics) websocketOpened() {
+	if m != nil {
+		m.add(m.websockets, 1)
+	}
+}
+
+func (m *Metrics) websocketClosed() {
+	if m != nil {
+		m.add(m.websockets, -1)
+	}
+}
+
+func (m *Metrics) websocketMessage(direction string) {
+	if m != nil {
+		m.add(m.wsMessages, 1, direction)
+	}
+}
+
+func (m *Metrics) handlerDone(route, method string, d time.Duration) {
+	if m != nil {
+		m.observe(m.handlerLatency, d, route, methodLabel(method))
+	}
+}
+
+func (m *Metrics) panicked(route, method string) {
+	if m != nil {
+		m.add(m.panics, 1, route, methodLabel(method))
+	}
+}
+
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) add(v float64, labelValues ...string) {
+	f.get(labelValues).value += v
+}
+
+func (f *metricFamily) observe(v float64, labelValues ...string) {
+	s := f.get(labelValues)
+	for i, upper := range f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// ServeHTTP writes the metrics
+// in Prometheus text format.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := bufio.NewWriter(rw)
+	m.mu.Lock()
+	for _, f := range m.families {
+		f.write(w)
+	}
+	m.mu.Unlock()
+	w.Flush()
+}
+
+func (f *metricFamily) write(w *bufio.Writer) {
+	w.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	w.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			w.WriteString(f.name + formatLabels(f.labels, s.labelValues, "") + " " + formatFloat(s.value) + "\n")
+			continue
+		}
+		for i, upper := range f.buckets {
+			w.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, formatFloat(upper)) + " " + strconv.FormatUint(s.counts[i], 10) + "\n")
+		}
+		w.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		w.WriteString(f.name + "_sum" + formatLabels(f.labels, s.labelValues, "") + " " + formatFloat(s.value) + "\n")
+		w.WriteString(f.name + "_count" + formatLabels(f.labels, s.labelValues, "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// SetMetrics enables the collection
+// of the proxy metrics.
+func (np *NatsProxy) SetMetrics(m *Metrics) {
+	np.metrics = m
+}
+
+// SetMetrics enables the collection
+// of the handler metrics.
+func (nc *NatsClient) SetMetrics(m *Metrics) {
+	nc.metrics = m
+}
@@ -0,0 +1,136 @@
+package natsproxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("Content type assertion failed")
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsRoute(t *testing.T) {
+	m := NewMetrics()
+	m.AddRoute("/users/:id")
+	m.AddRoute("/users/:id/orders")
+	m.AddRoute("/static/*filepath")
+	cases := map[string]string{
+		"/users/42":           "/users/:id",
+		"/users/42/orders":    "/users/:id/orders",
+		"/static/css/app.css": "/static/*filepath",
+		"/static":             otherRoute,
+		"/users/":             otherRoute,
+		"/health":             otherRoute,
+	}
+	for path, expected := range cases {
+		if route := m.route(path); route != expected {
+			t.Errorf("Route of %s assertion failed: %s", path, route)
+		}
+	}
+	var nilMetrics *Metrics
+	nilMetrics.requestStarted()
+	if nilMetrics.route("/users/42") != "" {
+		t.Error("Nil metrics assertion failed")
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := NewMetrics()
+	m.requestStarted()
+	m.requestFinished("/users/:id", "GET", 200)
+	m.roundTrip("/users/:id", "GET", 30*time.Millisecond)
+	m.roundTrip("/users/:id", "GET", 3*time.Second)
+	m.panicked(`/a"b`, "GET")
+	m.requestStarted()
+	m.requestFinished("/users/:id", "BREW", 418)
+	m.handlerDone(otherRoute, "X-RANDOM-1", time.Millisecond)
+
+	out := scrape(t, m)
+	expected := []string{
+		"# TYPE natsproxy_requests_total counter",
+		`natsproxy_requests_total{route="/users/:id",method="GET",status="200"} 1`,
+		"natsproxy_requests_in_flight 0",
+		`natsproxy_request_duration_seconds_bucket{route="/users/:id",method="GET",le="0.025"} 0`,
+		`natsproxy_request_duration_seconds_bucket{route="/users/:id",method="GET",le="0.05"} 1`,
+		`natsproxy_request_duration_seconds_bucket{route="/users/:id",method="GET",le="+Inf"} 2`,
+		`natsproxy_request_duration_seconds_count{route="/users/:id",method="GET"} 2`,
+		`natsproxy_client_panics_total{route="/a\"b",method="GET"} 1`,
+		`natsproxy_requests_total{route="/users/:id",method="other",status="418"} 1`,
+		`natsproxy_client_handler_duration_seconds_count{route="other",method="other"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "BREW") || strings.Contains(out, "X-RANDOM-1") {
+		t.Errorf("Unknown method labeled in:\n%s", out)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.AddRoute("/metered/:id")
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetMetrics(metrics)
+	proxyHandler.AddTimeout("^/metered/slow", 50*time.Millisecond)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.SetMetrics(metrics)
+	natsClient.SetPanicLogger(func(err interface{}, stack []byte) {})
+	natsClient.GET("/metered/:id", func(c *Context) {
+		switch c.PathVariable("id") {
+		case "panic":
+			panic("metered")
+		case "slow":
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	for _, id := range []string{"1", "panic", "slow"} {
+		resp, err := http.Get(server.URL + "/metered/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	out := scrape(t, metrics)
+	expected := []string{
+		`natsproxy_requests_total{route="/metered/:id",method="GET",status="200"} 1`,
+		`natsproxy_requests_total{route="/metered/:id",method="GET",status="500"} 1`,
+		`natsproxy_requests_total{route="/metered/:id",method="GET",status="504"} 1`,
+		`natsproxy_request_timeouts_total{route="/metered/:id",method="GET"} 1`,
+		`natsproxy_request_duration_seconds_count{route="/metered/:id",method="GET"} 3`,
+		`natsproxy_client_handler_duration_seconds_count{route="/metered/:id",method="GET"} 3`,
+		`natsproxy_client_panics_total{route="/metered/:id",method="GET"} 1`,
+		"natsproxy_requests_in_flight 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package natsproxy
+
+import (
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// RequestMiddleware inspects or modifies the
+// request before the proxy publishes it. The
+// middleware returning the response stops the
+// request, the response is written to the HTTP
+// client instead of the one of the service,
+// the response without status code as 200 OK.
+// The streamed request body is not available
+// to the middleware.
+type RequestMiddleware func(r *Request) *Response
+
+// hopByHopHeaders are the headers
+// meaningful only for the single connection.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Use adds the request middleware. The
+// middleware is applied in the order of
+// registration, the first one returning
+// the response stops the chain.
+func (np *NatsProxy) Use(middleware ...RequestMiddleware) {
+	np.middleware = append(np.middleware, middleware...)
+}
+
+// applyMiddleware runs the middleware
+// chain, it returns the response of the
+// middleware which stopped the request.
+func (np *NatsProxy) applyMiddleware(r *Request) *Response {
+	for _, middleware := range np.middleware {
+		if response := middleware(r); response != nil {
+			return response
+		}
+	}
+	return nil
+}
+
+// requestSubject returns the subject of
+// the request, the middleware could rewrite
+// the method and URL of the request.
+func (np *NatsProxy) requestSubject(r *Request, path string) string {
+	if len(np.middleware) == 0 {
+		return URLToNats(r.Method, path)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return URLToNats(r.Method, path)
+	}
+	return URLToNats(r.Method, u.Path)
+}
+
+// StripHopByHop is the request middleware
+// removing the hop-by-hop headers, including
+// the ones listed by the Connection header.
+func StripHopByHop(r *Request) *Response {
+	header := r.GetHeader()
+	if connection, ok := header["Connection"]; ok {
+		for _, value := range connection.Arr {
+			for _, name := range strings.Split(value, ",") {
+				header.Del(textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)))
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	return nil
+}
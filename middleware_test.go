@@ -0,0 +1,87 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats"
+)
+
+func TestProxyMiddleware(t *testing.T) {
+	var calls int64
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.GET("/middleware/new/:id", func(c *Context) {
+		atomic.AddInt64(&calls, 1)
+		chain := c.Request.GetHeader()["X-Chain"]
+		if chain != nil {
+			c.Response.Body = []byte(c.PathVariable("id") + ":" + strings.Join(chain.Arr, ","))
+		}
+	})
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.AddHook("^/middleware", func(r *Response) {
+		r.GetHeader().Set("Hook", "Hok")
+	})
+	proxyHandler.Use(func(r *Request) *Response {
+		r.GetHeader().Add("X-Chain", "first")
+		return nil
+	}, func(r *Request) *Response {
+		r.GetHeader().Add("X-Chain", "second")
+		if r.GetHeader().Get("X-Auth") == "" {
+			return &Response{StatusCode: http.StatusUnauthorized, Body: []byte("Not authenticated")}
+		}
+		return nil
+	})
+	// The path is rewritten.
+	proxyHandler.Use(func(r *Request) *Response {
+		r.URL = strings.Replace(r.URL, "/middleware/old/", "/middleware/new/", 1)
+		return nil
+	})
+
+	// The request is stopped
+	// before it is published.
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/middleware/old/1", nil)
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized || rw.Body.String() != "Not authenticated" {
+		t.Errorf("Short-circuit assertion failed: %d %s", rw.Code, rw.Body.String())
+	}
+	if rw.Header().Get("Hook") != "Hok" || rw.Header().Get(RequestIDHeader) == "" {
+		t.Error("Short-circuit response headers assertion failed")
+	}
+	if atomic.LoadInt64(&calls) != 0 {
+		t.Error("Stopped request reached the service")
+	}
+
+	req.Header.Set("X-Auth", "token")
+	rw = httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.String() != "1:first,second" {
+		t.Errorf("Middleware chain assertion failed: %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestStripHopByHop(t *testing.T) {
+	r := NewRequest()
+	header := r.GetHeader()
+	header.Set("Connection", "keep-alive, X-Private")
+	header.Set("Keep-Alive", "timeout=5")
+	header.Set("X-Private", "secret")
+	header.Set("Te", "trailers")
+	header.Set("X-Auth", "token")
+
+	if StripHopByHop(r) != nil {
+		t.Error("StripHopByHop stopped the request")
+	}
+	if len(header) != 1 || header.Get("X-Auth") != "token" {
+		t.Errorf("Stripped headers assertion failed: %v", header)
+	}
+}
@@ -12,6 +12,11 @@ It has these top-level messages:
 	Values
 	Request
 	Response
+	Chunk
+	WebsocketEvent
+	WebsocketMessage
+	RoomMembership
+	RoomPresence
 */
 package natsproxy
 
@@ -45,6 +50,20 @@ type Request struct {
 	Form        map[string]*Values `protobuf:"bytes,5,rep,name=Form,json=form" json:"Form,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	Header      map[string]*Values `protobuf:"bytes,6,rep,name=Header,json=header" json:"Header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	WebSocketID string             `protobuf:"bytes,7,opt,name=WebSocketID,json=webSocketID" json:"WebSocketID,omitempty"`
+	Deadline    int64              `protobuf:"varint,8,opt,name=Deadline,json=deadline" json:"Deadline,omitempty"`
+	// Subject to pull the body chunks
+	// from if the body is streamed.
+	BodyStream string `protobuf:"bytes,9,opt,name=BodyStream,json=bodyStream" json:"BodyStream,omitempty"`
+	// Subject to push the response
+	// body chunks to.
+	ResponseStream string `protobuf:"bytes,10,opt,name=ResponseStream,json=responseStream" json:"ResponseStream,omitempty"`
+	// W3C trace context of
+	// the calling span.
+	TraceParent string `protobuf:"bytes,11,opt,name=TraceParent,json=traceParent" json:"TraceParent,omitempty"`
+	TraceState  string `protobuf:"bytes,12,opt,name=TraceState,json=traceState" json:"TraceState,omitempty"`
+	// ID of the request assigned
+	// or accepted by the proxy.
+	RequestID string `protobuf:"bytes,13,opt,name=RequestID,json=requestID" json:"RequestID,omitempty"`
 }
 
 func (m *Request) Reset()                    { *m = Request{} }
@@ -53,10 +72,12 @@ func (*Request) ProtoMessage()               {}
 func (*Request) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
 type Response struct {
-	StatusCode int32              `protobuf:"varint,1,opt,name=StatusCode,json=statusCode" json:"StatusCode,omitempty"`
-	Header     map[string]*Values `protobuf:"bytes,2,rep,name=Header,json=header" json:"Header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	Body       []byte             `protobuf:"bytes,3,opt,name=Body,json=body,proto3" json:"Body,omitempty"`
-	DoUpgrade  bool               `protobuf:"varint,4,opt,name=DoUpgrade,json=doUpgrade" json:"DoUpgrade,omitempty"`
+	StatusCode  int32              `protobuf:"varint,1,opt,name=StatusCode,json=statusCode" json:"StatusCode,omitempty"`
+	Header      map[string]*Values `protobuf:"bytes,2,rep,name=Header,json=header" json:"Header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body        []byte             `protobuf:"bytes,3,opt,name=Body,json=body,proto3" json:"Body,omitempty"`
+	DoUpgrade   bool               `protobuf:"varint,4,opt,name=DoUpgrade,json=doUpgrade" json:"DoUpgrade,omitempty"`
+	Streamed    bool               `protobuf:"varint,5,opt,name=Streamed,json=streamed" json:"Streamed,omitempty"`
+	EventStream bool               `protobuf:"varint,6,opt,name=EventStream,json=eventStream" json:"EventStream,omitempty"`
 }
 
 func (m *Response) Reset()                    { *m = Response{} }
@@ -64,34 +85,113 @@ func (m *Response) String() string            { return proto.CompactTextString(m
 func (*Response) ProtoMessage()               {}
 func (*Response) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
+type Chunk struct {
+	Data  []byte `protobuf:"bytes,1,opt,name=Data,json=data,proto3" json:"Data,omitempty"`
+	EOF   bool   `protobuf:"varint,2,opt,name=EOF,json=eOF" json:"EOF,omitempty"`
+	Error string `protobuf:"bytes,3,opt,name=Error,json=error" json:"Error,omitempty"`
+}
+
+func (m *Chunk) Reset()                    { *m = Chunk{} }
+func (m *Chunk) String() string            { return proto.CompactTextString(m) }
+func (*Chunk) ProtoMessage()               {}
+func (*Chunk) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type WebsocketEvent struct {
+	WebsocketID string `protobuf:"bytes,1,opt,name=WebsocketID,json=websocketID" json:"WebsocketID,omitempty"`
+	Type        string `protobuf:"bytes,2,opt,name=Type,json=type" json:"Type,omitempty"`
+	Code        int32  `protobuf:"varint,3,opt,name=Code,json=code" json:"Code,omitempty"`
+	Reason      string `protobuf:"bytes,4,opt,name=Reason,json=reason" json:"Reason,omitempty"`
+	Error       string `protobuf:"bytes,5,opt,name=Error,json=error" json:"Error,omitempty"`
+}
+
+func (m *WebsocketEvent) Reset()                    { *m = WebsocketEvent{} }
+func (m *WebsocketEvent) String() string            { return proto.CompactTextString(m) }
+func (*WebsocketEvent) ProtoMessage()               {}
+func (*WebsocketEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+type WebsocketMessage struct {
+	Type int32  `protobuf:"varint,1,opt,name=Type,json=type" json:"Type,omitempty"`
+	Data []byte `protobuf:"bytes,2,opt,name=Data,json=data,proto3" json:"Data,omitempty"`
+}
+
+func (m *WebsocketMessage) Reset()                    { *m = WebsocketMessage{} }
+func (m *WebsocketMessage) String() string            { return proto.CompactTextString(m) }
+func (*WebsocketMessage) ProtoMessage()               {}
+func (*WebsocketMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type RoomMembership struct {
+	WebsocketID string `protobuf:"bytes,1,opt,name=WebsocketID,json=websocketID" json:"WebsocketID,omitempty"`
+	Room        string `protobuf:"bytes,2,opt,name=Room,json=room" json:"Room,omitempty"`
+	Join        bool   `protobuf:"varint,3,opt,name=Join,json=join" json:"Join,omitempty"`
+	Error       string `protobuf:"bytes,4,opt,name=Error,json=error" json:"Error,omitempty"`
+}
+
+func (m *RoomMembership) Reset()                    { *m = RoomMembership{} }
+func (m *RoomMembership) String() string            { return proto.CompactTextString(m) }
+func (*RoomMembership) ProtoMessage()               {}
+func (*RoomMembership) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type RoomPresence struct {
+	WebsocketIDs []string `protobuf:"bytes,1,rep,name=WebsocketIDs,json=websocketIDs" json:"WebsocketIDs,omitempty"`
+}
+
+func (m *RoomPresence) Reset()                    { *m = RoomPresence{} }
+func (m *RoomPresence) String() string            { return proto.CompactTextString(m) }
+func (*RoomPresence) ProtoMessage()               {}
+func (*RoomPresence) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
 func init() {
 	proto.RegisterType((*Values)(nil), "Values")
 	proto.RegisterType((*Request)(nil), "Request")
 	proto.RegisterType((*Response)(nil), "Response")
+	proto.RegisterType((*Chunk)(nil), "Chunk")
+	proto.RegisterType((*WebsocketEvent)(nil), "WebsocketEvent")
+	proto.RegisterType((*WebsocketMessage)(nil), "WebsocketMessage")
+	proto.RegisterType((*RoomMembership)(nil), "RoomMembership")
+	proto.RegisterType((*RoomPresence)(nil), "RoomPresence")
 }
 
 var fileDescriptor0 = []byte{
-	// 349 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xac, 0x92, 0xbf, 0x4e, 0xc3, 0x30,
-	0x10, 0xc6, 0x95, 0x3f, 0x75, 0x9b, 0x0b, 0x20, 0x64, 0x01, 0xb2, 0x2a, 0x40, 0x55, 0x07, 0xc4,
-	0x00, 0x19, 0xca, 0x82, 0x98, 0xa0, 0x14, 0x54, 0xa4, 0xb2, 0xb8, 0x2a, 0x48, 0x6c, 0x09, 0x76,
-	0xa9, 0x54, 0x5a, 0x07, 0xc7, 0x01, 0xf2, 0x94, 0x3c, 0x05, 0xef, 0x81, 0xed, 0x90, 0xd0, 0x81,
-	0x09, 0xb1, 0x7d, 0xfe, 0xbe, 0x8b, 0x2f, 0xf7, 0xf3, 0xc1, 0x46, 0x2a, 0x85, 0x12, 0x49, 0x3e,
-	0x8d, 0xac, 0xe8, 0xb6, 0x01, 0xdd, 0xc5, 0xcf, 0x39, 0xcf, 0xf0, 0x26, 0x78, 0xb1, 0x94, 0xc4,
-	0xe9, 0x78, 0x87, 0x01, 0x35, 0xb2, 0xfb, 0xe9, 0x42, 0x93, 0xf2, 0x17, 0x1d, 0x2a, 0x93, 0x4e,
-	0xe8, 0x48, 0xa7, 0x8e, 0x49, 0x73, 0x3a, 0xc2, 0x3b, 0x80, 0x6e, 0xb9, 0x9a, 0x09, 0x46, 0x5c,
-	0x6b, 0xa2, 0x85, 0x3d, 0xe1, 0x7d, 0x00, 0xca, 0x17, 0x42, 0xf1, 0x0b, 0xc6, 0x24, 0xf1, 0x6c,
-	0x06, 0xb2, 0x76, 0x30, 0x06, 0xbf, 0x2f, 0x58, 0x41, 0x7c, 0x9d, 0xac, 0x51, 0x3f, 0xd1, 0x1a,
-	0x1f, 0x80, 0x7f, 0x2d, 0xe4, 0x82, 0x34, 0x74, 0xf3, 0xb0, 0x87, 0xa3, 0xef, 0xae, 0x91, 0x31,
-	0xaf, 0x96, 0x4a, 0x16, 0xd4, 0x9f, 0x6a, 0x89, 0x8f, 0x00, 0x0d, 0x79, 0xcc, 0xb8, 0x24, 0xc8,
-	0x56, 0x6e, 0xd5, 0x95, 0xa5, 0x5d, 0xd6, 0xa2, 0x99, 0x3d, 0xe0, 0x0e, 0x84, 0xf7, 0x3c, 0x19,
-	0x8b, 0xc7, 0x39, 0x57, 0x37, 0x03, 0xd2, 0xb4, 0xbf, 0x12, 0xbe, 0xfd, 0x58, 0xed, 0x73, 0x08,
-	0xea, 0x16, 0x66, 0xc4, 0x39, 0x2f, 0xaa, 0x11, 0xb5, 0xc4, 0x7b, 0xd0, 0x78, 0x35, 0x70, 0xec,
-	0x84, 0x61, 0xaf, 0x19, 0x95, 0xa8, 0x68, 0xe9, 0x9e, 0xb9, 0xa7, 0x4e, 0xbb, 0x0f, 0xe1, 0x4a,
-	0xeb, 0x3f, 0xdd, 0xd1, 0xfd, 0x70, 0xa0, 0x45, 0x79, 0x96, 0x8a, 0x65, 0xc6, 0x0d, 0xbe, 0xb1,
-	0x8a, 0x55, 0x9e, 0x5d, 0x0a, 0xc6, 0xed, 0x45, 0x0d, 0x0a, 0x59, 0xed, 0xe0, 0xe3, 0x1a, 0x81,
-	0x6b, 0x11, 0x6c, 0x47, 0xd5, 0xa7, 0xbf, 0x32, 0xa8, 0x68, 0x7b, 0x2b, 0xb4, 0x77, 0x21, 0x18,
-	0x88, 0x49, 0xfa, 0x24, 0x75, 0x85, 0x7d, 0x86, 0x16, 0x0d, 0x58, 0x65, 0xfc, 0xc7, 0x44, 0xfd,
-	0xf5, 0xa1, 0xfb, 0x10, 0x2c, 0x63, 0x95, 0xe9, 0x25, 0x7b, 0x2f, 0x12, 0x64, 0x77, 0xed, 0xe4,
-	0x2b, 0x00, 0x00, 0xff, 0xff, 0xea, 0xc6, 0x72, 0xd5, 0x7d, 0x02, 0x00, 0x00,
+	// 648 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0x4f, 0x4f, 0xdb, 0x4e,
+	0x10, 0x95, 0xe3, 0x3f, 0xd8, 0x93, 0x10, 0xa1, 0x15, 0x3f, 0xb4, 0x8a, 0xf8, 0x55, 0x51, 0x0e,
+	0x28, 0x87, 0x36, 0x07, 0x7a, 0xa9, 0x38, 0xb5, 0x90, 0x20, 0xa8, 0x40, 0xa0, 0x05, 0x8a, 0xd4,
+	0xdb, 0x3a, 0x1e, 0x48, 0x1a, 0xec, 0x75, 0x77, 0x37, 0xb4, 0xfe, 0x06, 0xbd, 0xf4, 0x83, 0xf4,
+	0x5b, 0x56, 0xbb, 0xfe, 0x13, 0x57, 0xea, 0xa1, 0xaa, 0x7a, 0xf3, 0xbc, 0x99, 0xf1, 0xbc, 0x37,
+	0x6f, 0x6c, 0xe8, 0xe7, 0x52, 0x68, 0x11, 0xaf, 0x1f, 0x26, 0xf6, 0x61, 0x34, 0x80, 0xe0, 0x03,
+	0x7f, 0x5a, 0xa3, 0x22, 0x3b, 0xe0, 0x72, 0x29, 0xa9, 0x33, 0x74, 0xc7, 0x11, 0x33, 0x8f, 0xa3,
+	0x1f, 0x1e, 0x6c, 0x31, 0xfc, 0xbc, 0x46, 0xa5, 0x4d, 0xf6, 0x8e, 0x5d, 0x50, 0x67, 0xe8, 0x98,
+	0xec, 0x9a, 0x5d, 0x90, 0x3d, 0x08, 0x2e, 0x51, 0x2f, 0x44, 0x42, 0x3b, 0x16, 0x0c, 0x52, 0x1b,
+	0x91, 0x17, 0x00, 0x0c, 0x53, 0xa1, 0xf1, 0x5d, 0x92, 0x48, 0xea, 0xda, 0x1c, 0xc8, 0x06, 0x21,
+	0x04, 0xbc, 0x63, 0x91, 0x14, 0xd4, 0x1b, 0x3a, 0xe3, 0x1e, 0xf3, 0x62, 0x91, 0x14, 0xe4, 0x00,
+	0xbc, 0x53, 0x21, 0x53, 0xea, 0x0f, 0xdd, 0x71, 0xf7, 0x90, 0x4c, 0xaa, 0xa9, 0x13, 0x03, 0xce,
+	0x32, 0x2d, 0x0b, 0xe6, 0x3d, 0x08, 0x99, 0x92, 0x97, 0x10, 0x9c, 0x21, 0x4f, 0x50, 0xd2, 0xc0,
+	0x56, 0xee, 0x36, 0x95, 0x25, 0x5c, 0xd6, 0x06, 0x0b, 0x1b, 0x90, 0x21, 0x74, 0xef, 0x31, 0xbe,
+	0x11, 0xf3, 0x15, 0xea, 0xf3, 0x29, 0xdd, 0xb2, 0x54, 0xba, 0x5f, 0x36, 0x10, 0x19, 0x40, 0x38,
+	0x45, 0x9e, 0x3c, 0x2d, 0x33, 0xa4, 0xe1, 0xd0, 0x19, 0xbb, 0x2c, 0x4c, 0xaa, 0xd8, 0xe8, 0x30,
+	0x3c, 0x6f, 0xb4, 0x44, 0x9e, 0xd2, 0xa8, 0xd4, 0x11, 0x37, 0x08, 0x39, 0x80, 0x3e, 0x43, 0x95,
+	0x8b, 0x4c, 0x61, 0x55, 0x03, 0xb6, 0xa6, 0x2f, 0x7f, 0x41, 0x0d, 0x8b, 0x5b, 0xc9, 0xe7, 0x78,
+	0xcd, 0x25, 0x66, 0x9a, 0x76, 0x4b, 0x16, 0x7a, 0x03, 0x99, 0x49, 0xb6, 0xe2, 0x46, 0x73, 0x8d,
+	0xb4, 0x57, 0x4e, 0xd2, 0x0d, 0x42, 0xf6, 0x21, 0xaa, 0x64, 0x9e, 0x4f, 0xe9, 0xb6, 0x4d, 0x47,
+	0xb2, 0x06, 0x06, 0x6f, 0x21, 0x6a, 0xd6, 0x64, 0x6c, 0x5a, 0x61, 0x51, 0xdb, 0xb4, 0xc2, 0x82,
+	0xfc, 0x0f, 0xfe, 0xb3, 0x31, 0xd8, 0xba, 0xd4, 0x3d, 0xdc, 0x9a, 0x94, 0x76, 0xb3, 0x12, 0x3d,
+	0xea, 0xbc, 0x71, 0x06, 0xc7, 0xd0, 0x6d, 0xad, 0xef, 0xaf, 0xde, 0x31, 0xfa, 0xde, 0x81, 0xb0,
+	0x5e, 0x87, 0x11, 0x64, 0x98, 0xaf, 0xd5, 0x89, 0x48, 0xd0, 0xbe, 0xc8, 0x67, 0xa0, 0x1a, 0x84,
+	0xbc, 0x6a, 0x6c, 0xec, 0x58, 0x1b, 0xff, 0x9b, 0xd4, 0xad, 0xbf, 0xf5, 0xb1, 0xbe, 0x18, 0xb7,
+	0x75, 0x31, 0xfb, 0x10, 0x4d, 0xc5, 0x5d, 0xfe, 0x28, 0x79, 0x82, 0xf6, 0x94, 0x42, 0x16, 0x25,
+	0x35, 0x60, 0x7c, 0x2d, 0xb7, 0x8f, 0x09, 0xf5, 0x6d, 0x32, 0x54, 0x55, 0x6c, 0xfc, 0x98, 0x3d,
+	0x63, 0xa6, 0x2b, 0xd3, 0x02, 0x9b, 0xee, 0xe2, 0x06, 0xfa, 0x27, 0xfb, 0x38, 0x01, 0xff, 0x64,
+	0xb1, 0xce, 0x56, 0x86, 0xfc, 0x94, 0x6b, 0x6e, 0xdb, 0x7b, 0xcc, 0x4b, 0xb8, 0xe6, 0xe6, 0x8d,
+	0xb3, 0xab, 0x53, 0xdb, 0x1d, 0x32, 0x17, 0xaf, 0x4e, 0xc9, 0x2e, 0xf8, 0x33, 0x29, 0x45, 0xfd,
+	0xbd, 0xf8, 0x68, 0x82, 0xd1, 0x37, 0x07, 0xfa, 0xf7, 0x18, 0x2b, 0x7b, 0xae, 0x96, 0x74, 0x75,
+	0xd3, 0xaa, 0xbe, 0x69, 0xa7, 0xb9, 0xe9, 0x1a, 0x32, 0x03, 0x6f, 0x8b, 0x1c, 0xab, 0xaf, 0xd2,
+	0xd3, 0x45, 0x8e, 0x06, 0xb3, 0x56, 0xb8, 0xd6, 0x0a, 0x6f, 0x6e, 0x4c, 0xd8, 0x83, 0x80, 0x21,
+	0x57, 0x22, 0xb3, 0xeb, 0x8b, 0x58, 0x20, 0x6d, 0xb4, 0xa1, 0xe2, 0xb7, 0xa9, 0x1c, 0xc1, 0x4e,
+	0x33, 0xf7, 0x12, 0x95, 0xe2, 0x8f, 0xd8, 0x4c, 0x2a, 0x0d, 0x6e, 0x26, 0x59, 0xb9, 0x9d, 0x8d,
+	0xdc, 0x51, 0x0e, 0x7d, 0x26, 0x44, 0x7a, 0x89, 0x69, 0x8c, 0x52, 0x2d, 0x96, 0xf9, 0x9f, 0xa9,
+	0x30, 0x3d, 0xb5, 0x0a, 0x29, 0x44, 0x6a, 0xb0, 0xf7, 0x62, 0x99, 0x59, 0x15, 0x21, 0xf3, 0x3e,
+	0x89, 0x65, 0x8b, 0xad, 0xd7, 0x66, 0x7b, 0x08, 0x3d, 0xd3, 0x7d, 0x2d, 0x51, 0x61, 0x36, 0x47,
+	0x32, 0x82, 0x5e, 0x6b, 0x9e, 0xaa, 0x7e, 0x72, 0xbd, 0xd6, 0x40, 0x75, 0xbc, 0x7d, 0xd6, 0xf9,
+	0x18, 0x65, 0x5c, 0xab, 0x5c, 0x8a, 0xaf, 0x45, 0x1c, 0xd8, 0xff, 0xe3, 0xeb, 0x9f, 0x03, 0x00,
+	0x13, 0x9d, 0x22, 0xbc, 0x31, 0x05, 0x00, 0x00,
 }
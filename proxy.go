@@ -2,10 +2,11 @@ package natsproxy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"regexp"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -20,22 +21,11 @@ var (
 	ErrNatsClientNotConnected = fmt.Errorf("Client not connected")
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 // HookFunc is the function that is
 // used to modify response just before its
 // transformed to HTTP response
 type HookFunc func(*Response)
 
-type webSocketMapper struct {
-	toNats   map[*websocket.Conn]string
-	fromNats map[string]*websocket.Conn
-}
-
 // NatsProxy serves as a proxy
 // between gnats and http. It automatically
 // translates the HTTP requests to nats
@@ -44,15 +34,22 @@ type webSocketMapper struct {
 // the message is sent.
 type NatsProxy struct {
 	conn         *nats.Conn
-	hooks        map[string]hookGroup
+	hooks        *hookRegistry
 	wsMapper     *webSocketMapper
+	rooms        *roomRegistry
+	metrics      *Metrics
+	exporter     Exporter
+	logger       Logger
 	requestPool  RequestPool
 	responsePool ResponsePool
-}
+	timeouts     *timeoutPolicy
+	errorHandler ErrorHandler
+	middleware   []RequestMiddleware
+	streams      *streamRegistry
+	chunkSize    int
+	heartbeat    time.Duration
 
-type hookGroup struct {
-	regexp *regexp.Regexp
-	hooks  []HookFunc
+	websocketConfig WebsocketConfig
 }
 
 // NewNatsProxy creates an
@@ -61,16 +58,31 @@ func NewNatsProxy(conn *nats.Conn) (*NatsProxy, error) {
 	if err := testConnection(conn); err != nil {
 		return nil, err
 	}
-	return &NatsProxy{
-		conn,
-		make(map[string]hookGroup, 0),
-		&webSocketMapper{
-			make(map[*websocket.Conn]string, 0),
-			make(map[string]*websocket.Conn, 0),
-		},
-		NewRequestPool(),
-		NewResponsePool(),
-	}, nil
+	np := &NatsProxy{
+		conn:         conn,
+		hooks:        newHookRegistry(),
+		wsMapper:     newWebSocketMapper(),
+		rooms:        newRoomRegistry(conn, NopLogger{}),
+		requestPool:  NewRequestPool(),
+		responsePool: NewResponsePool(),
+		timeouts:     newTimeoutPolicy(),
+		errorHandler: DefaultErrorHandler,
+		streams:      newStreamRegistry(),
+		heartbeat:    DefaultHeartbeat,
+		logger:       NopLogger{},
+
+		websocketConfig: DefaultWebsocketConfig(),
+	}
+
+	// All streamed bodies of the proxy
+	// share the single subscription.
+	_, err := conn.Subscribe(np.streams.prefix+"*.*", func(m *nats.Msg) {
+		np.dispatchStream(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return np, nil
 }
 
 func (np *NatsProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -80,45 +92,122 @@ func (np *NatsProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	request := np.requestPool.GetRequest()
 	defer np.requestPool.Put(request)
 
-	err := request.FromHTTP(req)
+	if req == nil {
+		np.handleError(rw, req, newInternalError(errors.New("natsproxy: Request cannot be nil")))
+		return
+	}
+
+	// The ID is returned even
+	// with the error response.
+	id := requestID(req)
+	rw.Header().Set(RequestIDHeader, id)
+
+	route := np.metrics.route(req.URL.Path)
+	status := 0
+	np.metrics.requestStarted()
+	defer func() {
+		np.metrics.requestFinished(route, req.Method, status)
+	}()
+	span := startSpan("HTTP "+req.Method, req.Header.Get(TraceparentHeader), np.exporter)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.target", req.URL.Path)
+	defer func() {
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+		span.finish()
+	}()
+	fail := func(err *ProxyError) {
+		status = err.StatusCode
+		np.handleError(rw, req, err)
+	}
+
+	// The cross-site websocket handshake
+	// is rejected before the service is
+	// contacted, so it has no side effects.
+	if IsWebSocketRequest(req) && !checkOrigin(np.websocketConfig, req) {
+		fail(newForbiddenOriginError())
+		return
+	}
+
+	// The large request body is streamed
+	// to the service in chunks, the response
+	// body could be streamed by the service.
+	stream := np.streams.open()
+	var bodyDone chan struct{}
+	defer func() {
+		np.streams.close(stream)
+		if bodyDone != nil {
+			<-bodyDone
+		}
+	}()
+	streamBody := np.streamsBody(req)
+
+	err := request.fromHTTP(req, !streamBody)
 	if err != nil {
-		http.Error(rw, "Cannot process request", http.StatusInternalServerError)
+		fail(newInternalError(err))
 		return
 	}
+	request.ResponseStream = np.streams.subject(stream, stream_RES)
+	request.TraceParent = span.Traceparent()
+	request.TraceState = req.Header.Get(TracestateHeader)
+	request.RequestID = id
+
+	// The middleware could modify
+	// or stop the request.
+	if response := np.applyMiddleware(request); response != nil {
+		if response.StatusCode == 0 {
+			response.StatusCode = http.StatusOK
+		}
+		np.applyHooks(req.URL.Path, response)
+		status = int(response.StatusCode)
+		writeResponse(rw, response)
+		return
+	}
+	if streamBody {
+		request.BodyStream = np.streams.subject(stream, stream_REQ)
+		bodyDone = make(chan struct{})
+		go func() {
+			np.serveBody(stream, req.Body)
+			close(bodyDone)
+		}()
+	}
+
+	// The service is notified about the
+	// time the proxy stops waiting.
+	timeout := np.timeouts.resolve(req.URL.Path, req.Header)
+	request.SetDeadline(time.Now().Add(timeout))
 
 	// Serialize the request.
 	reqBytes, err := proto.Marshal(request)
 	if err != nil {
-		http.Error(rw, "Cannot process request", http.StatusInternalServerError)
+		fail(newInternalError(err))
 		return
 	}
 
 	// Post request to message queue
+	start := time.Now()
 	msg, respErr := np.conn.Request(
-		URLToNats(req.Method, req.URL.Path),
+		np.requestSubject(request, req.URL.Path),
 		reqBytes,
-		10*time.Second)
+		timeout)
+	np.metrics.roundTrip(route, req.Method, time.Since(start))
 	if respErr != nil {
-		http.Error(rw, "No response", http.StatusInternalServerError)
+		if respErr == nats.ErrTimeout {
+			np.metrics.timeout(route, req.Method)
+		}
+		fail(newRequestError(np.conn, respErr))
 		return
 	}
 	response := np.responsePool.GetResponse()
 	err = response.ReadFrom(msg.Data)
 	defer np.responsePool.Put(response)
 	if err != nil {
-		log.Println("nats-proxy:" + err.Error())
-		http.Error(rw, "Cannot deserialize response", http.StatusInternalServerError)
+		np.logger.Error("cannot decode response", "request_id", id, "error", err)
+		np.metrics.decodeError()
+		fail(newBadGatewayError(err))
 		return
 	}
 
-	// Apply hook if regex match
-	for _, hG := range np.hooks {
-		if hG.regexp.MatchString(req.URL.Path) {
-			for _, hook := range hG.hooks {
-				hook(response)
-			}
-		}
-	}
+	np.applyHooks(req.URL.Path, response)
 
 	// If response contains
 	// the permission to do ws upgrade, the
@@ -128,12 +217,24 @@ func (np *NatsProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// and WS_OUT+wsID as outcoming)
 	if request.IsWebSocket() && response.DoUpgrade {
 		header := http.Header{}
+		header.Set(RequestIDHeader, id)
 		copyHeader(response.Header, header)
-		if conn, err := upgrader.Upgrade(rw, req, header); err == nil {
-			np.activateWSProxySubject(conn, request.WebSocketID)
+		if conn, err := np.upgrader(req, header).Upgrade(rw, req, header); err == nil {
+			status = http.StatusSwitchingProtocols
+			np.activateWSProxySubject(conn, request.WebSocketID, id)
 		} else {
-			log.Println("natsproxy error: " + err.Error())
+			status = http.StatusBadRequest
+			np.logger.Warn("websocket upgrade failed", "request_id", id, "error", err)
+			np.abortWebsocket(request.WebSocketID, err)
 		}
+		return
+	}
+
+	status = int(response.StatusCode)
+	if response.EventStream {
+		np.writeEventStream(rw, req, response, stream, id)
+	} else if response.Streamed {
+		np.writeStreamedResponse(rw, req, response, stream, timeout, id)
 	} else {
 		writeResponse(rw, response)
 	}
@@ -143,89 +244,138 @@ func (np *NatsProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 // AddHook add the hook to modify,
 // process response just before
 // its transformed to HTTP form.
+// The hooks are grouped by urlRegex in
+// the order the regex was first added,
+// the hooks of one regex are applied in
+// the order of registration. The hooks
+// could be added while the proxy serves.
 func (np *NatsProxy) AddHook(urlRegex string, hook HookFunc) error {
-	hG, ok := np.hooks[urlRegex]
-	if !ok {
-		regexp, err := regexp.Compile(urlRegex)
-		if err != nil {
-			return err
-		}
-		hooks := make([]HookFunc, 1)
-		hooks[0] = hook
-		np.hooks[urlRegex] = hookGroup{
-			regexp,
-			hooks,
-		}
-	} else {
-		hG.hooks = append(hG.hooks, hook)
+	return np.hooks.add(urlRegex, hook)
+}
+
+// applyHooks applies the hooks
+// whose regex matches the path.
+func (np *NatsProxy) applyHooks(path string, response *Response) {
+	// The response without
+	// header has nil map.
+	if response.Header == nil {
+		response.Header = make(map[string]*Values)
+	}
+	for _, hook := range np.hooks.match(path) {
+		hook(response)
 	}
-	return nil
 }
 
-func (np *NatsProxy) activateWSProxySubject(conn *websocket.Conn, wsID string) {
-	np.addToWSMapper(conn, wsID)
-	np.conn.Subscribe("WS_OUT"+wsID, func(m *nats.Msg) {
-		err := conn.WriteMessage(websocket.TextMessage, m.Data)
+// activateWSProxySubject bridges the upgraded
+// connection with the WS_IN and WS_OUT subjects
+// and notifies the service about the lifecycle
+// of the websocket. The requestID is the ID
+// of the upgrade request.
+func (np *NatsProxy) activateWSProxySubject(conn *websocket.Conn, wsID string, requestID string) {
+	np.wsMapper.add(conn, wsID)
+	ws := newProxyWebsocket(conn, wsID)
+	ws.requestID = requestID
+	ws.metrics = np.metrics
+	var closing atomic.Value
+	handlers := map[string]nats.MsgHandler{
+		ws_OUT + wsID: func(m *nats.Msg) {
+			if err := ws.write(m.Data); err != nil {
+				np.logWebsocketError(ws, "cannot write websocket message", err)
+			}
+		},
+		ws_CLOSE + wsID: func(m *nats.Msg) {
+			event, err := closeWebsocket(conn, m.Data)
+			if err != nil {
+				np.logWebsocketError(ws, "cannot decode websocket close", err)
+				return
+			}
+			closing.Store(event)
+		},
+		ws_ROOMS + wsID: func(m *nats.Msg) {
+			np.rooms.handle(ws, m)
+		},
+	}
+	subs := make([]*nats.Subscription, 0, len(handlers))
+	unsubscribe := func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}
+	for subject, handler := range handlers {
+		sub, err := np.conn.Subscribe(subject, handler)
 		if err != nil {
-			log.Println("Error writing a message", err)
+			np.logWebsocketError(ws, "cannot subscribe websocket", err)
+			np.rooms.leaveAll(ws)
+			unsubscribe()
+			np.wsMapper.remove(conn, wsID)
+			conn.Close()
+			np.abortWebsocket(wsID, err)
+			return
 		}
-	})
+		subs = append(subs, sub)
+	}
+	np.publishWebsocketEvent(&WebsocketEvent{WebsocketID: wsID, Type: WebsocketOpen})
+
+	np.metrics.websocketOpened()
+	config := np.websocketConfig
+	done := make(chan struct{})
+	config.keepAlive(conn, done)
 	go func() {
+		defer func() {
+			close(done)
+			np.metrics.websocketClosed()
+			np.rooms.leaveAll(ws)
+			unsubscribe()
+			np.wsMapper.remove(conn, wsID)
+			conn.Close()
+		}()
 		for {
-			if _, p, err := conn.ReadMessage(); err == nil {
-				np.conn.Publish("WS_IN"+wsID, p)
-			} else {
-				np.removeFromWSMapper(conn, wsID)
-				conn.Close()
-				// If websocket is closed normally RFC6455
-				// code 1000, then no error logged
-				if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-					logWebsocketError(wsID, err)
+			p, err := readWebsocketMessage(conn)
+			if err == nil {
+				np.conn.Publish(ws_IN+wsID, p)
+				np.metrics.websocketMessage("in")
+				if config.IdleTimeout > 0 && closing.Load() == nil {
+					conn.SetReadDeadline(time.Now().Add(config.IdleTimeout))
 				}
-				break
+				continue
+			}
+			event := &WebsocketEvent{
+				WebsocketID: wsID,
+				Type:        WebsocketClose,
+				Code:        websocket.CloseAbnormalClosure,
+			}
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				event.Code = int32(closeErr.Code)
+				event.Reason = closeErr.Text
+			} else if initiated, ok := closing.Load().(*WebsocketEvent); ok {
+				// The peer did not answer
+				// the close frame in time.
+				event.Code = initiated.Code
+				event.Reason = initiated.Reason
+			} else {
+				np.publishWebsocketEvent(&WebsocketEvent{
+					WebsocketID: wsID,
+					Type:        WebsocketError,
+					Error:       err.Error(),
+				})
+			}
+			// If websocket is closed normally RFC6455
+			// code 1000, then no error logged
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				np.logWebsocketError(ws, "websocket closed", err)
 			}
+			np.publishWebsocketEvent(event)
+			return
 		}
 	}()
 }
 
-func (np *NatsProxy) addToWSMapper(conn *websocket.Conn, wsID string) {
-	np.wsMapper.fromNats[wsID] = conn
-	np.wsMapper.toNats[conn] = wsID
-}
-
-func (np *NatsProxy) removeFromWSMapper(conn *websocket.Conn, wsID string) {
-	delete(np.wsMapper.fromNats, wsID)
-	delete(np.wsMapper.toNats, conn)
-}
-
-func (np *NatsProxy) resetWSMapper() {
-	np.wsMapper.fromNats = make(map[string]*websocket.Conn, 0)
-	np.wsMapper.toNats = make(map[*websocket.Conn]string, 0)
-}
-
 func (np *NatsProxy) closeAllWebsockets() error {
-	var outerError error
-	closed := make([]string, 0)
-	for key, val := range np.wsMapper.fromNats {
-		if err := val.Close(); err != nil {
-			outerError = fmt.Errorf("nats-proxy: closing websocket ID: %s caused error: %s", key, err.Error())
-		}
-		closed = append(closed, key)
-	}
-	if outerError == nil {
-		np.resetWSMapper()
-	} else {
-		// Remove just closed
-		for _, val := range closed {
-			np.removeFromWSMapper(np.wsMapper.fromNats[val], val)
-		}
-	}
-
-	return outerError
+	return np.wsMapper.closeAll()
 }
 
-func logWebsocketError(wsID string, err error) {
-	log.Printf("nats-proxy: underlying websocker ID: %s error: %s", wsID, err.Error())
+func (np *NatsProxy) logWebsocketError(ws *proxyWebsocket, msg string, err error) {
+	np.logger.Warn(msg, "request_id", ws.requestID, "websocket_id", ws.id, "error", err)
 }
 
 func writeResponse(rw http.ResponseWriter, response *Response) {
@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -162,6 +164,35 @@ func TestProxyServeHttpError(t *testing.T) {
 	}
 }
 
+func TestProxyNoResponseError(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetTimeout(50 * time.Millisecond)
+	defer proxyConn.Close()
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/nobody/listens", nil)
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+
+	// Depending on the server
+	// version the missing subscriber is
+	// reported as timeout or no responders.
+	if rw.Code != http.StatusGatewayTimeout && rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("No response status assertion failed: %d", rw.Code)
+	}
+
+	var handled *ProxyError
+	proxyHandler.SetErrorHandler(func(rw http.ResponseWriter, req *http.Request, err *ProxyError) {
+		handled = err
+		ProblemJSONErrorHandler(rw, req, err)
+	})
+	rw = httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if handled == nil || handled.StatusCode != rw.Code {
+		t.Error("Custom error handler assertion failed")
+	}
+}
+
 func BenchmarkProxyPool(b *testing.B) {
 	fmt.Println("Executing TestProxyPoolNoForm")
 	proxyConn, _ := nats.Connect(nats_url)
@@ -235,6 +266,51 @@ func TestWebSocket(t *testing.T) {
 
 }
 
+func TestWebSocketConcurrentUpgrades(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.GET("/ws/:token", func(c *Context) {
+		c.Response.DoUpgrade = c.Request.IsWebSocket()
+	})
+	clientConn.Flush()
+
+	addr := strings.Replace(server.URL, "http", "ws", -1)
+	var wg sync.WaitGroup
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Hooks are added
+			// while serving.
+			proxyHandler.AddHook("^/ws/"+strconv.Itoa(i%10), func(r *Response) {})
+			conn, _, err := websocket.DefaultDialer.Dial(addr+"/ws/"+strconv.Itoa(i), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1000, "OK"))
+			conn.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for proxyHandler.wsMapper.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if proxyHandler.wsMapper.len() != 0 {
+		t.Error("Closed websockets assertion failed")
+	}
+}
+
 func BenchmarkWebsocketWrite(t *testing.B) {
 	proxyConn, _ := nats.Connect(nats_url)
 	defer proxyConn.Close()
@@ -276,3 +352,67 @@ func BenchmarkWebsocketWrite(t *testing.B) {
 		t.Fail()
 	}
 }
+
+func TestProxySpecialPaths(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.GET("/special/:name", func(c *Context) {
+		c.Response.Body = []byte(c.PathVariable("name"))
+	})
+	natsClient.GET("/special/static", func(c *Context) {
+		c.Response.Body = []byte("static")
+	})
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	cases := map[string]string{
+		"/special/report.v2.pdf": "report.v2.pdf",
+		"/special/*":             "*",
+		"/special/%3E":           ">",
+		"/special/with%20space":  "with space",
+		"/special/%C4%8Daj":      "čaj",
+	}
+	for path, name := range cases {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:3000"+path, nil)
+		rw := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rw, req)
+		if rw.Body.String() != name {
+			t.Errorf("Path variable of %s assertion failed: %q", path, rw.Body.String())
+		}
+	}
+}
+
+func TestCatchAllRoute(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	if _, err := natsClient.GET("/catchall/*path/edit", func(c *Context) {}); err != ErrInvalidRoute {
+		t.Error("Invalid catch-all route assertion failed")
+	}
+	natsClient.GET("/catchall/*filepath", func(c *Context) {
+		c.Response.Body = []byte(c.PathVariable("filepath"))
+	})
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	cases := map[string]string{
+		"/catchall/index.html":          "index.html",
+		"/catchall/css/site.v2.css":     "css/site.v2.css",
+		"/catchall/deep/er/path%20file": "deep/er/path file",
+	}
+	for path, filepath := range cases {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:3000"+path, nil)
+		rw := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rw, req)
+		if rw.Body.String() != filepath {
+			t.Errorf("Catch-all variable of %s assertion failed: %q", path, rw.Body.String())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package natsproxy
+
+import "runtime/debug"
+
+// RecoveryHandler renders the response
+// of the request whose handler panicked.
+// The err is the value recovered from panic.
+type RecoveryHandler func(c *Context, err interface{})
+
+// PanicLogger logs the value recovered
+// from panic with the stack trace.
+type PanicLogger func(err interface{}, stack []byte)
+
+// DefaultRecoveryHandler writes the
+// plain 500 Internal Server Error response.
+func DefaultRecoveryHandler(c *Context, err interface{}) {
+	c.Response.StatusCode = 500
+	c.Response.Body = []byte("Internal Server Error")
+}
+
+// SetRecoveryHandler sets the handler
+// rendering the response if the handler
+// of the request panics.
+func (nc *NatsClient) SetRecoveryHandler(handler RecoveryHandler) {
+	nc.recoveryHandler = handler
+}
+
+// SetPanicLogger sets the logger
+// of the recovered panics. By default
+// the panics are logged by the Logger
+// of the client.
+func (nc *NatsClient) SetPanicLogger(logger PanicLogger) {
+	nc.panicLogger = logger
+}
+
+// SetPropagatePanics disables the
+// recovery, so the panic of the handler
+// propagates. Useful for tests.
+func (nc *NatsClient) SetPropagatePanics(propagate bool) {
+	nc.propagatePanics = propagate
+}
+
+// runChain executes the handler
+// chain of the context. The panic
+// of the handler is converted to
+// the response by recovery handler.
+func (nc *NatsClient) runChain(c *Context) {
+	if !nc.propagatePanics {
+		defer nc.recoverContext(c)
+	}
+	c.Next()
+}
+
+func (nc *NatsClient) recoverContext(c *Context) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	nc.logPanic(c.RequestID(), err)
+	nc.metrics.panicked(c.route, c.Request.Method)
+
+	// The streamed response
+	// cannot be replaced.
+	if c.isStreaming() {
+		c.bodyWriter.fail("nats-proxy: handler panic")
+		return
+	}
+	if c.replied {
+		return
+	}
+
+	// Drop the partially
+	// written response.
+	c.Abort()
+	c.Response.Header = make(map[string]*Values)
+	c.Response.Body = nil
+	c.Response.DoUpgrade = false
+
+	handler := nc.recoveryHandler
+	if handler == nil {
+		handler = DefaultRecoveryHandler
+	}
+	handler(c, err)
+}
+
+// recoverMsg recovers the panic of
+// the websocket message handler, which
+// has no response to be written.
+func (nc *NatsClient) recoverMsg() {
+	if nc.propagatePanics {
+		return
+	}
+	if err := recover(); err != nil {
+		nc.logPanic("", err)
+		nc.metrics.panicked("websocket", "")
+	}
+}
+
+// logPanic logs the panic by the panic
+// logger if set, otherwise by the logger
+// of the client.
+func (nc *NatsClient) logPanic(requestID string, err interface{}) {
+	if nc.panicLogger != nil {
+		nc.panicLogger(err, debug.Stack())
+		return
+	}
+	nc.logger.Error("handler panic recovered", "request_id", requestID, "panic", err, "stack", string(debug.Stack()))
+}
@@ -0,0 +1,68 @@
+package natsproxy
+
+import (
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	var logged interface{}
+	nc := &NatsClient{}
+	nc.SetPanicLogger(func(err interface{}, stack []byte) {
+		logged = err
+		if len(stack) == 0 {
+			t.Error("Stack assertion failed")
+		}
+	})
+
+	handlerCalled := false
+	c := newContext(buildParamMap("/test"), NewResponse(), &Request{})
+	c.handlers = NatsHandlers{
+		func(c *Context) {
+			c.Response.GetHeader().Set("X-Partial", "yes")
+			panic("boom")
+		},
+		func(c *Context) {
+			handlerCalled = true
+		},
+	}
+	nc.runChain(c)
+
+	if logged != "boom" {
+		t.Error("Panic logger assertion failed")
+	}
+	if handlerCalled {
+		t.Error("Handler called after panic")
+	}
+	if c.Response.StatusCode != 500 || c.Response.GetHeader().Get("X-Partial") != "" {
+		t.Error("Recovery response assertion failed")
+	}
+}
+
+func TestRecoveryHandler(t *testing.T) {
+	nc := &NatsClient{}
+	nc.SetPanicLogger(func(err interface{}, stack []byte) {})
+	nc.SetRecoveryHandler(func(c *Context, err interface{}) {
+		c.JSON(500, map[string]interface{}{"error": err})
+	})
+
+	c := newContext(buildParamMap("/test"), NewResponse(), &Request{})
+	c.handlers = NatsHandlers{func(c *Context) { panic("boom") }}
+	nc.runChain(c)
+	if string(c.Response.Body) != `{"error":"boom"}` {
+		t.Error("Recovery handler assertion failed")
+	}
+}
+
+func TestPropagatePanics(t *testing.T) {
+	nc := &NatsClient{}
+	nc.SetPropagatePanics(true)
+
+	defer func() {
+		if err := recover(); err != "boom" {
+			t.Error("Panic propagation assertion failed")
+		}
+	}()
+	c := newContext(buildParamMap("/test"), NewResponse(), &Request{})
+	c.handlers = NatsHandlers{func(c *Context) { panic("boom") }}
+	nc.runChain(c)
+}
@@ -0,0 +1,140 @@
+package natsproxy
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// webSocketMapper maps the websocket
+// IDs to the upgraded connections. It is
+// shared by ServeHTTP and the websocket
+// reader goroutines, so it is guarded by mutex.
+type webSocketMapper struct {
+	mu       sync.RWMutex
+	toNats   map[*websocket.Conn]string
+	fromNats map[string]*websocket.Conn
+}
+
+func newWebSocketMapper() *webSocketMapper {
+	return &webSocketMapper{
+		toNats:   make(map[*websocket.Conn]string),
+		fromNats: make(map[string]*websocket.Conn),
+	}
+}
+
+func (m *webSocketMapper) add(conn *websocket.Conn, wsID string) {
+	m.mu.Lock()
+	m.fromNats[wsID] = conn
+	m.toNats[conn] = wsID
+	m.mu.Unlock()
+}
+
+// remove deletes the mapping of
+// the connection. The ID is released only
+// if it still belongs to the connection,
+// so the connection closed late does not
+// remove its successor with the same ID.
+func (m *webSocketMapper) remove(conn *websocket.Conn, wsID string) {
+	m.mu.Lock()
+	if m.fromNats[wsID] == conn {
+		delete(m.fromNats, wsID)
+	}
+	delete(m.toNats, conn)
+	m.mu.Unlock()
+}
+
+func (m *webSocketMapper) get(wsID string) (*websocket.Conn, bool) {
+	m.mu.RLock()
+	conn, ok := m.fromNats[wsID]
+	m.mu.RUnlock()
+	return conn, ok
+}
+
+func (m *webSocketMapper) len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.fromNats)
+}
+
+// closeAll closes all mapped
+// connections. The connections are
+// closed outside of the lock, because
+// closing makes the reader goroutines
+// remove them from the mapper.
+func (m *webSocketMapper) closeAll() error {
+	m.mu.Lock()
+	conns := m.fromNats
+	m.fromNats = make(map[string]*websocket.Conn)
+	m.toNats = make(map[*websocket.Conn]string)
+	m.mu.Unlock()
+
+	var outerError error
+	for wsID, conn := range conns {
+		if err := conn.Close(); err != nil {
+			outerError = fmt.Errorf("nats-proxy: closing websocket ID: %s caused error: %s", wsID, err.Error())
+		}
+	}
+	return outerError
+}
+
+type hookGroup struct {
+	regexp *regexp.Regexp
+	hooks  []HookFunc
+}
+
+// hookRegistry holds the hooks grouped
+// by the regex, the groups in the order
+// the regex was first added. The hooks
+// could be added while the proxy serves.
+type hookRegistry struct {
+	mu     sync.RWMutex
+	index  map[string]int
+	groups []hookGroup
+}
+
+func newHookRegistry() *hookRegistry {
+	return &hookRegistry{
+		index: make(map[string]int),
+	}
+}
+
+func (r *hookRegistry) add(urlRegex string, hook HookFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i, ok := r.index[urlRegex]; ok {
+		// Copy on write, the
+		// matched hooks could be
+		// running outside the lock.
+		hooks := make([]HookFunc, len(r.groups[i].hooks), len(r.groups[i].hooks)+1)
+		copy(hooks, r.groups[i].hooks)
+		r.groups[i].hooks = append(hooks, hook)
+		return nil
+	}
+	regexp, err := regexp.Compile(urlRegex)
+	if err != nil {
+		return err
+	}
+	r.index[urlRegex] = len(r.groups)
+	r.groups = append(r.groups, hookGroup{
+		regexp,
+		[]HookFunc{hook},
+	})
+	return nil
+}
+
+// match returns the hooks
+// registered for the path.
+func (r *hookRegistry) match(path string) []HookFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var hooks []HookFunc
+	for _, hG := range r.groups {
+		if hG.regexp.MatchString(path) {
+			hooks = append(hooks, hG.hooks...)
+		}
+	}
+	return hooks
+}
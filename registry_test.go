@@ -0,0 +1,87 @@
+package natsproxy
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketMapper(t *testing.T) {
+	mapper := newWebSocketMapper()
+	old, successor := &websocket.Conn{}, &websocket.Conn{}
+	mapper.add(old, "1")
+	mapper.add(successor, "1")
+
+	// Late removal of the old
+	// connection keeps the successor.
+	mapper.remove(old, "1")
+	if conn, ok := mapper.get("1"); !ok || conn != successor {
+		t.Error("Successor assertion failed")
+	}
+	mapper.remove(successor, "1")
+	if _, ok := mapper.get("1"); ok {
+		t.Error("Remove assertion failed")
+	}
+}
+
+func TestWebSocketMapperConcurrency(t *testing.T) {
+	mapper := newWebSocketMapper()
+	var wg sync.WaitGroup
+	for i := 0; i < 5000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := &websocket.Conn{}
+			wsID := strconv.Itoa(i % 100)
+			mapper.add(conn, wsID)
+			mapper.get(wsID)
+			mapper.len()
+			mapper.remove(conn, wsID)
+		}(i)
+	}
+	wg.Wait()
+	if mapper.len() != 0 || len(mapper.toNats) != 0 {
+		t.Error("Empty mapper assertion failed")
+	}
+}
+
+func TestHookRegistryConcurrency(t *testing.T) {
+	registry := newHookRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 2000; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			registry.add("^/path/"+strconv.Itoa(i%10), func(r *Response) {})
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for _, hook := range registry.match("/path/" + strconv.Itoa(i%10)) {
+				hook(nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(registry.groups) != 10 || len(registry.match("/path/3")) != 200 {
+		t.Error("Hook count assertion failed")
+	}
+}
+
+func TestHookOrder(t *testing.T) {
+	registry := newHookRegistry()
+	var order []int
+	registry.add("^/a", func(r *Response) { order = append(order, 1) })
+	registry.add(".*", func(r *Response) { order = append(order, 2) })
+	registry.add("^/a", func(r *Response) { order = append(order, 3) })
+	for _, hook := range registry.match("/a") {
+		hook(nil)
+	}
+	if len(order) != 3 || order[0] != 1 || order[1] != 3 || order[2] != 2 {
+		t.Errorf("Hook order assertion failed: %v", order)
+	}
+	if err := registry.add("[", func(r *Response) {}); err == nil {
+		t.Error("Invalid regexp assertion failed")
+	}
+}
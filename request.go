@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/nats-io/nuid"
@@ -42,7 +43,34 @@ func (r *Request) GetWebSocketID() string {
 	return r.WebSocketID
 }
 
+// SetDeadline sets the absolute time
+// after which the requester does not
+// wait for the response.
+func (r *Request) SetDeadline(deadline time.Time) {
+	r.Deadline = deadline.UnixNano()
+}
+
+// GetDeadline returns the deadline
+// of the request. The ok is false if
+// the request has no deadline.
+func (r *Request) GetDeadline() (deadline time.Time, ok bool) {
+	if r == nil || r.Deadline == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, r.Deadline), true
+}
+
+// FromHTTP transforms the
+// http.Request to the Request
+// including the whole body.
 func (r *Request) FromHTTP(req *http.Request) error {
+	return r.fromHTTP(req, true)
+}
+
+// fromHTTP transforms the http.Request,
+// the body is read only if readBody is set,
+// otherwise it is left for streaming.
+func (r *Request) fromHTTP(req *http.Request, readBody bool) error {
 
 	if req == nil {
 		return errors.New("natsproxy: Request cannot be nil")
@@ -56,7 +84,7 @@ func (r *Request) FromHTTP(req *http.Request) error {
 
 	buf := bytes.NewBuffer(r.Body)
 	buf.Reset()
-	if req.Body != nil {
+	if req.Body != nil && readBody {
 		if _, err := io.Copy(buf, req.Body); err != nil {
 			return err
 		}
@@ -71,6 +99,12 @@ func (r *Request) FromHTTP(req *http.Request) error {
 	r.Header = headerMap
 	r.RemoteAddr = req.RemoteAddr
 	r.WebSocketID = wsID
+	r.Deadline = 0
+	r.BodyStream = ""
+	r.ResponseStream = ""
+	r.TraceParent = ""
+	r.TraceState = ""
+	r.RequestID = ""
 	r.Body = buf.Bytes()
 	return nil
 }
@@ -90,6 +124,12 @@ func (req *Request) reset() {
 	req.Body = req.Body[0:0]
 	req.RemoteAddr = req.RemoteAddr[0:0]
 	req.URL = req.URL[0:0]
+	req.Deadline = 0
+	req.BodyStream = ""
+	req.ResponseStream = ""
+	req.TraceParent = ""
+	req.TraceState = ""
+	req.RequestID = ""
 }
 
 type RequestPool struct {
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 )
@@ -73,3 +74,20 @@ func BenchmarkMarshallRequest(b *testing.B) {
 	}
 
 }
+
+func TestRequestDeadline(t *testing.T) {
+	original := &Request{}
+	if _, ok := original.GetDeadline(); ok {
+		t.Error("Empty deadline assertion failed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	original.SetDeadline(deadline)
+	payload, _ := proto.Marshal(original)
+
+	copyObj := &Request{}
+	copyObj.UnmarshallFrom(payload)
+	if d, ok := copyObj.GetDeadline(); !ok || !d.Equal(deadline) {
+		t.Error("Deadline not equals")
+	}
+}
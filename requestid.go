@@ -0,0 +1,47 @@
+package natsproxy
+
+import (
+	"net/http"
+
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// RequestIDHeader is the HTTP header
+	// carrying the ID of the request.
+	RequestIDHeader = "X-Request-Id"
+
+	// maxRequestIDLength limits the
+	// length of the accepted request ID.
+	maxRequestIDLength = 128
+)
+
+// requestID returns the ID sent by the
+// caller if valid, otherwise the new one.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	return nuid.Next()
+}
+
+// validRequestID accepts the non empty
+// IDs of printable ASCII characters, so the
+// ID could be safely logged and echoed.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of the
+// request assigned by the proxy.
+func (c *Context) RequestID() string {
+	return c.Request.RequestID
+}
@@ -0,0 +1,82 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+func TestRequestID(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.GET("/requestid/echo", func(c *Context) {
+		c.Response.Body = []byte(c.RequestID())
+	})
+	clientConn.Flush()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	// The ID is assigned
+	// by the proxy.
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/requestid/echo", nil)
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	id := rw.Header().Get(RequestIDHeader)
+	if id == "" || rw.Body.String() != id {
+		t.Errorf("Assigned request ID assertion failed: %q %q", id, rw.Body.String())
+	}
+
+	// The valid ID of
+	// the caller is accepted.
+	req.Header.Set("X-Request-ID", "caller-id-1")
+	rw = httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Header().Get(RequestIDHeader) != "caller-id-1" || rw.Body.String() != "caller-id-1" {
+		t.Error("Accepted request ID assertion failed")
+	}
+
+	// The invalid ID is replaced.
+	req.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	rw = httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if id := rw.Header().Get(RequestIDHeader); id == "" || len(id) > maxRequestIDLength {
+		t.Error("Replaced request ID assertion failed")
+	}
+}
+
+func TestRequestIDOnError(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetTimeout(10 * time.Millisecond)
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/requestid/nobody", nil)
+	req.Header.Set(RequestIDHeader, "failing")
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Header().Get(RequestIDHeader) != "failing" {
+		t.Error("Request ID of error response assertion failed")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	valid := []string{"abc", "0f3a-11", strings.Repeat("a", maxRequestIDLength)}
+	for _, id := range valid {
+		if !validRequestID(id) {
+			t.Errorf("%q should be valid", id)
+		}
+	}
+	invalid := []string{"", "a b", "a\nb", "čau", strings.Repeat("a", maxRequestIDLength+1)}
+	for _, id := range invalid {
+		if validRequestID(id) {
+			t.Errorf("%q should be invalid", id)
+		}
+	}
+}
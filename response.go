@@ -38,11 +38,17 @@ func (r *Response) ReadFrom(responseData []byte) error {
 	return nil
 }
 
+// reset restores the response
+// to the state of NewResponse, so
+// the pooled response does not leak
+// the flags of the previous one.
 func (res *Response) reset() {
 	res.Header = make(map[string]*Values)
 	res.Body = res.Body[0:0]
 	res.DoUpgrade = false
-	res.StatusCode = int32(0)
+	res.Streamed = false
+	res.EventStream = false
+	res.StatusCode = int32(200)
 }
 
 type ResponsePool struct {
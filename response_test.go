@@ -17,3 +17,17 @@ func TestDecodeResponseError(t *testing.T) {
 	}
 
 }
+
+func TestResponseReset(t *testing.T) {
+	r := NewResponse()
+	r.StatusCode = 404
+	r.GetHeader().Set("X-Test", "1")
+	r.Body = []byte("body")
+	r.DoUpgrade = true
+	r.Streamed = true
+	r.EventStream = true
+	r.reset()
+	if r.StatusCode != 200 || len(r.Header) != 0 || len(r.Body) != 0 || r.DoUpgrade || r.Streamed || r.EventStream {
+		t.Errorf("Response reset assertion failed: %v", r)
+	}
+}
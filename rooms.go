@@ -0,0 +1,316 @@
+package natsproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats"
+)
+
+// DefaultPresenceTimeout is the time
+// RoomMembers collects the replies
+// of the proxies.
+const DefaultPresenceTimeout = 250 * time.Millisecond
+
+var (
+	// ErrInvalidRoom is returned if the
+	// room name is empty or contains
+	// characters not allowed in NATS subject.
+	ErrInvalidRoom = errors.New("nats-proxy: invalid room name")
+
+	// ErrWebsocketNotFound is returned if no
+	// proxy holds the websocket with given ID.
+	ErrWebsocketNotFound = errors.New("nats-proxy: websocket not found")
+)
+
+// roomRegistry holds the rooms joined by
+// the websockets of the proxy. The proxy
+// subscribes the broadcast and presence
+// subjects of the room while it has
+// at least one local member.
+type roomRegistry struct {
+	mu     sync.Mutex
+	conn   *nats.Conn
+	logger Logger
+	rooms  map[string]*room
+}
+
+type room struct {
+	members map[string]*proxyWebsocket
+	subs    []*nats.Subscription
+}
+
+func newRoomRegistry(conn *nats.Conn, logger Logger) *roomRegistry {
+	return &roomRegistry{
+		conn:   conn,
+		logger: logger,
+		rooms:  make(map[string]*room),
+	}
+}
+
+// handle serves the membership
+// request of the service.
+func (r *roomRegistry) handle(ws *proxyWebsocket, m *nats.Msg) {
+	membership := &RoomMembership{}
+	err := proto.Unmarshal(m.Data, membership)
+	if err == nil && !validRoom(membership.Room) {
+		err = ErrInvalidRoom
+	}
+	if err == nil {
+		if membership.Join {
+			err = r.join(membership.Room, ws)
+		} else {
+			r.leave(membership.Room, ws)
+		}
+	}
+	if err != nil {
+		membership.Error = err.Error()
+	}
+	if data, err := proto.Marshal(membership); err == nil && m.Reply != "" {
+		r.conn.Publish(m.Reply, data)
+	}
+}
+
+// join adds the websocket to the room.
+// The closed websocket is rejected, so the
+// request racing with the close does not
+// add it back.
+func (r *roomRegistry) join(name string, ws *proxyWebsocket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ws.closed {
+		return ErrWebsocketNotFound
+	}
+	rm, ok := r.rooms[name]
+	if !ok {
+		broadcastSub, err := r.conn.Subscribe(ws_ROOM+name, func(m *nats.Msg) {
+			r.broadcast(name, m)
+		})
+		if err != nil {
+			return err
+		}
+		presenceSub, err := r.conn.Subscribe(ws_PRESENCE+name, func(m *nats.Msg) {
+			r.presence(name, m)
+		})
+		if err != nil {
+			broadcastSub.Unsubscribe()
+			return err
+		}
+		rm = &room{
+			members: make(map[string]*proxyWebsocket),
+			subs:    []*nats.Subscription{broadcastSub, presenceSub},
+		}
+		r.rooms[name] = rm
+	}
+	rm.members[ws.id] = ws
+	ws.rooms[name] = struct{}{}
+	return nil
+}
+
+func (r *roomRegistry) leave(name string, ws *proxyWebsocket) {
+	r.mu.Lock()
+	r.leaveLocked(name, ws)
+	r.mu.Unlock()
+}
+
+// leaveAll removes the closed
+// websocket from all its rooms and
+// marks it closed.
+func (r *roomRegistry) leaveAll(ws *proxyWebsocket) {
+	r.mu.Lock()
+	ws.closed = true
+	for name := range ws.rooms {
+		r.leaveLocked(name, ws)
+	}
+	r.mu.Unlock()
+}
+
+func (r *roomRegistry) leaveLocked(name string, ws *proxyWebsocket) {
+	delete(ws.rooms, name)
+	rm, ok := r.rooms[name]
+	if !ok || rm.members[ws.id] != ws {
+		return
+	}
+	delete(rm.members, ws.id)
+	if len(rm.members) == 0 {
+		for _, sub := range rm.subs {
+			sub.Unsubscribe()
+		}
+		delete(r.rooms, name)
+	}
+}
+
+// members returns the snapshot of the
+// local members, so the messages are
+// written outside of the lock.
+func (r *roomRegistry) members(name string) []*proxyWebsocket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[name]
+	if !ok {
+		return nil
+	}
+	members := make([]*proxyWebsocket, 0, len(rm.members))
+	for _, ws := range rm.members {
+		members = append(members, ws)
+	}
+	return members
+}
+
+func (r *roomRegistry) broadcast(name string, m *nats.Msg) {
+	for _, ws := range r.members(name) {
+		if err := ws.write(m.Data); err != nil {
+			r.logger.Warn("cannot write room message", "room", name, "request_id", ws.requestID, "websocket_id", ws.id, "error", err)
+		}
+	}
+}
+
+func (r *roomRegistry) presence(name string, m *nats.Msg) {
+	members := r.members(name)
+	if len(members) == 0 || m.Reply == "" {
+		return
+	}
+	presence := &RoomPresence{}
+	for _, ws := range members {
+		presence.WebsocketIDs = append(presence.WebsocketIDs, ws.id)
+	}
+	data, err := proto.Marshal(presence)
+	if err != nil {
+		r.logger.Error("cannot encode room presence", "room", name, "error", err)
+		return
+	}
+	r.conn.Publish(m.Reply, data)
+}
+
+// validRoom checks the room name
+// is the single NATS subject token.
+func validRoom(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ".*> \t\r\n")
+}
+
+// JoinRoom adds the websocket to the room.
+// The websocket receives the messages broadcast
+// to the room until it leaves or closes.
+func (nc *NatsClient) JoinRoom(websocketID, room string) error {
+	return nc.changeRoom(websocketID, room, true)
+}
+
+// LeaveRoom removes the
+// websocket from the room.
+func (nc *NatsClient) LeaveRoom(websocketID, room string) error {
+	return nc.changeRoom(websocketID, room, false)
+}
+
+func (nc *NatsClient) changeRoom(websocketID, room string, join bool) error {
+	if !validRoom(room) {
+		return ErrInvalidRoom
+	}
+	data, err := proto.Marshal(&RoomMembership{
+		WebsocketID: websocketID,
+		Room:        room,
+		Join:        join,
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := nc.conn.Request(ws_ROOMS+websocketID, data, nc.timeout)
+	if err == nats.ErrTimeout {
+		return ErrWebsocketNotFound
+	} else if err != nil {
+		return err
+	}
+	reply := &RoomMembership{}
+	if err := proto.Unmarshal(msg.Data, reply); err != nil {
+		return err
+	}
+	switch reply.Error {
+	case "":
+		return nil
+	case ErrWebsocketNotFound.Error():
+		return ErrWebsocketNotFound
+	}
+	return errors.New(reply.Error)
+}
+
+// Broadcast writes given bytes as the text
+// message to all websockets in the room.
+func (nc *NatsClient) Broadcast(room string, data []byte) error {
+	return nc.broadcast(room, WebsocketText, data)
+}
+
+// BroadcastBinary writes given bytes as the
+// binary message to all websockets in the room.
+func (nc *NatsClient) BroadcastBinary(room string, data []byte) error {
+	return nc.broadcast(room, WebsocketBinary, data)
+}
+
+// BroadcastJSON writes struct serialized
+// to JSON to all websockets in the room.
+func (nc *NatsClient) BroadcastJSON(room string, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return nc.Broadcast(room, data)
+}
+
+func (nc *NatsClient) broadcast(room string, msgType int, data []byte) error {
+	if !validRoom(room) {
+		return ErrInvalidRoom
+	}
+	envelope, err := proto.Marshal(&WebsocketMessage{
+		Type: int32(msgType),
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	return nc.conn.Publish(ws_ROOM+room, envelope)
+}
+
+// RoomMembers returns the IDs of websockets
+// in the room across all proxies. The replies
+// of the proxies are collected for
+// DefaultPresenceTimeout, unless
+// overridden by WithTimeout.
+func (nc *NatsClient) RoomMembers(room string, opts ...SendOption) ([]string, error) {
+	if !validRoom(room) {
+		return nil, ErrInvalidRoom
+	}
+	o := &sendOptions{timeout: DefaultPresenceTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	inbox := nats.NewInbox()
+	sub, err := nc.conn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	if err := nc.conn.PublishRequest(ws_PRESENCE+room, inbox, nil); err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0)
+	deadline := time.Now().Add(o.timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if err == nats.ErrTimeout {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		presence := &RoomPresence{}
+		if err := proto.Unmarshal(msg.Data, presence); err != nil {
+			return nil, err
+		}
+		members = append(members, presence.WebsocketIDs...)
+	}
+	sort.Strings(members)
+	return members, nil
+}
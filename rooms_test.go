@@ -0,0 +1,147 @@
+package natsproxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats"
+)
+
+func TestValidRoom(t *testing.T) {
+	for _, room := range []string{"", "a.b", "a*", ">", "a b"} {
+		if validRoom(room) {
+			t.Errorf("Invalid room %q assertion failed", room)
+		}
+	}
+	if !validRoom("chat-1") {
+		t.Error("Valid room assertion failed")
+	}
+}
+
+func TestJoinClosedWebsocket(t *testing.T) {
+	conn, _ := nats.Connect(nats_url)
+	defer conn.Close()
+	rooms := newRoomRegistry(conn, NopLogger{})
+	ws := newProxyWebsocket(nil, "closed-ws")
+
+	if err := rooms.join("closing", ws); err != nil {
+		t.Fatal(err)
+	}
+	rooms.leaveAll(ws)
+	if err := rooms.join("closing", ws); err != ErrWebsocketNotFound {
+		t.Errorf("Closed websocket join assertion failed: %v", err)
+	}
+	if len(rooms.rooms) != 0 || len(ws.rooms) != 0 {
+		t.Error("Closed websocket membership assertion failed")
+	}
+}
+
+func TestRooms(t *testing.T) {
+	// Two proxies share
+	// the rooms.
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		proxyConn, _ := nats.Connect(nats_url)
+		defer proxyConn.Close()
+		proxyHandler, _ := NewNatsProxy(proxyConn)
+		server := httptest.NewServer(proxyHandler)
+		defer server.Close()
+		addrs = append(addrs, strings.Replace(server.URL, "http", "ws", -1))
+	}
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.SetTimeout(200 * time.Millisecond)
+	joined := make(chan string, 3)
+	natsClient.GET("/rooms/:room", func(c *Context) {
+		c.Response.DoUpgrade = c.Request.IsWebSocket()
+		socketID, _ := c.GetWebsocketID()
+		room := c.PathVariable("room")
+		natsClient.HandleWebsocketEvents(socketID, func(e *WebsocketEvent) {
+			if e.Type != WebsocketOpen {
+				return
+			}
+			if room != "none" {
+				if err := natsClient.JoinRoom(socketID, room); err != nil {
+					t.Error(err)
+				}
+			}
+			joined <- socketID
+		})
+	})
+	clientConn.Flush()
+
+	dial := func(addr, room string) (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial(addr+"/rooms/"+room, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case id := <-joined:
+			return conn, id
+		case <-time.After(2 * time.Second):
+			t.Fatal("Join assertion failed")
+		}
+		return nil, ""
+	}
+	first, firstID := dial(addrs[0], "chat")
+	defer first.Close()
+	second, secondID := dial(addrs[1], "chat")
+	defer second.Close()
+	outsider, _ := dial(addrs[0], "none")
+	defer outsider.Close()
+
+	if err := natsClient.Broadcast("chat", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, p, err := conn.ReadMessage(); err != nil || string(p) != "hello" {
+			t.Errorf("Broadcast assertion failed: %q %v", p, err)
+		}
+	}
+	outsider.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := outsider.ReadMessage(); err == nil {
+		t.Error("Outsider received broadcast")
+	}
+
+	members, err := natsClient.RoomMembers("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || !contains(members, firstID) || !contains(members, secondID) {
+		t.Errorf("Presence assertion failed: %v", members)
+	}
+
+	if err := natsClient.LeaveRoom(secondID, "chat"); err != nil {
+		t.Error(err)
+	}
+	first.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1000, ""))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(members) > 0 && time.Now().Before(deadline) {
+		members, _ = natsClient.RoomMembers("chat", WithTimeout(50*time.Millisecond))
+	}
+	if len(members) != 0 {
+		t.Errorf("Left room assertion failed: %v", members)
+	}
+
+	if err := natsClient.JoinRoom("unknown", "chat"); err != ErrWebsocketNotFound {
+		t.Errorf("Unknown websocket assertion failed: %v", err)
+	}
+	if err := natsClient.Broadcast("a.b", nil); err != ErrInvalidRoom {
+		t.Error("Invalid room assertion failed")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
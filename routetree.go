@@ -0,0 +1,243 @@
+package natsproxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats"
+)
+
+// ErrRouteConflict is returned in the
+// strict mode if the route is ambiguous
+// with the already subscribed one.
+var ErrRouteConflict = fmt.Errorf("nats-proxy: route conflicts with subscribed route")
+
+// routeEntry is the route
+// stored in the routeTree.
+type routeEntry struct {
+	subject     string
+	group       string
+	handler     nats.MsgHandler
+	constraints pathConstraints
+}
+
+// routeTree resolves the subject of the
+// request to the most specific route. The
+// static token wins over the "*" token, which
+// wins over the ">" token, the tokens are
+// compared from left to right.
+type routeTree struct {
+	static   map[string]*routeTree
+	param    *routeTree
+	catchAll *routeTree
+	entry    *routeEntry
+}
+
+func newRouteTree() *routeTree {
+	return &routeTree{}
+}
+
+func (t *routeTree) insert(e *routeEntry) {
+	n := t
+	for _, token := range strings.Split(e.subject, ".") {
+		n = n.child(token, true)
+	}
+	n.entry = e
+}
+
+func (t *routeTree) remove(subject string) {
+	t.removeTokens(strings.Split(subject, "."))
+}
+
+// removeTokens removes the entry and
+// prunes the empty nodes. It returns
+// true if the node is empty.
+func (t *routeTree) removeTokens(tokens []string) bool {
+	if len(tokens) == 0 {
+		t.entry = nil
+	} else if n := t.child(tokens[0], false); n != nil && n.removeTokens(tokens[1:]) {
+		switch tokens[0] {
+		case "*":
+			t.param = nil
+		case ">":
+			t.catchAll = nil
+		default:
+			delete(t.static, tokens[0])
+		}
+	}
+	return t.entry == nil && t.param == nil && t.catchAll == nil && len(t.static) == 0
+}
+
+func (t *routeTree) child(token string, create bool) *routeTree {
+	var n **routeTree
+	switch token {
+	case "*":
+		n = &t.param
+	case ">":
+		n = &t.catchAll
+	default:
+		if c, ok := t.static[token]; ok || !create {
+			return c
+		}
+		if t.static == nil {
+			t.static = make(map[string]*routeTree)
+		}
+		t.static[token] = newRouteTree()
+		return t.static[token]
+	}
+	if *n == nil && create {
+		*n = newRouteTree()
+	}
+	return *n
+}
+
+// match returns the most specific
+// route of the subject or nil. If checked
+// is set, the routes whose constraints
+// reject the subject are skipped.
+func (t *routeTree) match(subject string, checked bool) *routeEntry {
+	tokens := strings.Split(subject, ".")
+	return t.matchTokens(tokens, 0, checked)
+}
+
+func (t *routeTree) matchTokens(tokens []string, i int, checked bool) *routeEntry {
+	if i == len(tokens) {
+		return t.accept(tokens, checked)
+	}
+	if n := t.static[tokens[i]]; n != nil {
+		if e := n.matchTokens(tokens, i+1, checked); e != nil {
+			return e
+		}
+	}
+	if t.param != nil {
+		if e := t.param.matchTokens(tokens, i+1, checked); e != nil {
+			return e
+		}
+	}
+	if t.catchAll != nil {
+		return t.catchAll.accept(tokens, checked)
+	}
+	return nil
+}
+
+func (t *routeTree) accept(tokens []string, checked bool) *routeEntry {
+	if t.entry == nil || checked && !t.entry.constraints.acceptTokens(tokens) {
+		return nil
+	}
+	return t.entry
+}
+
+// ambiguousRoutes returns true if the route
+// subjects a and b match the common subject
+// and none of them is at least as specific
+// as the other one in every token. Such
+// routes are resolved from left to right.
+func ambiguousRoutes(a, b string) bool {
+	at, bt := strings.Split(a, "."), strings.Split(b, ".")
+	return overlapTokens(at, bt) && !dominates(at, bt) && !dominates(bt, at)
+}
+
+func overlapTokens(a, b []string) bool {
+	for i := 0; ; i++ {
+		switch {
+		case i == len(a) || i == len(b):
+			return len(a) == len(b)
+		case a[i] == ">" || b[i] == ">":
+			return true
+		case a[i] != "*" && b[i] != "*" && a[i] != b[i]:
+			return false
+		}
+	}
+}
+
+// dominates returns true if a is
+// at least as specific as b in
+// every token.
+func dominates(a, b []string) bool {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if tokenRank(a, i) < tokenRank(b, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenRank ranks the token of
+// the route, the tokens behind the ">"
+// are covered by the catch-all.
+func tokenRank(tokens []string, i int) int {
+	if i >= len(tokens) {
+		if tokens[len(tokens)-1] == ">" {
+			return 0
+		}
+		return -1
+	}
+	switch tokens[i] {
+	case ">":
+		return 0
+	case "*":
+		return 1
+	}
+	return 2
+}
+
+// dispatch serves the message received by
+// the route subscription of subject. If the
+// more specific route of the client matches,
+// the message is left to it. The message
+// delivered to the queue group shared with
+// that route is passed to its handler, as
+// the route itself does not receive it.
+// If the constraints of all routes reject the
+// subject, the most specific route answers.
+func (nc *NatsClient) dispatch(m *nats.Msg, subject, group string, handler nats.MsgHandler) {
+	nc.mu.RLock()
+	e := nc.routeTree.match(m.Subject, true)
+	if e == nil {
+		e = nc.autoTree.match(m.Subject, true)
+	}
+	if e == nil {
+		e = nc.routeTree.match(m.Subject, false)
+	}
+	if e == nil {
+		e = nc.autoTree.match(m.Subject, false)
+	}
+	nc.mu.RUnlock()
+
+	switch {
+	case e == nil || e.subject == subject:
+		handler(m)
+	case group != "" && e.group == group:
+		e.handler(m)
+	}
+}
+
+// checkRoute reports the ambiguity of the
+// route with the subscribed routes. In strict
+// mode the ErrRouteConflict is returned,
+// otherwise the warning is logged.
+// Must be called with nc.mu held.
+func (nc *NatsClient) checkRoute(method, url, subject string) error {
+	for other, s := range nc.routes {
+		if s.Method != method || !ambiguousRoutes(subject, other) {
+			continue
+		}
+		if nc.strictRoutes {
+			return ErrRouteConflict
+		}
+		nc.logger.Warn("ambiguous route, resolved from left to right", "route", url, "conflicts_with", s.URL, "method", method)
+	}
+	return nil
+}
+
+// SetStrictRoutes enables the rejection
+// of the ambiguous routes by ErrRouteConflict.
+// By default the ambiguous route is
+// subscribed and the warning logged.
+func (nc *NatsClient) SetStrictRoutes(strict bool) {
+	nc.strictRoutes = strict
+}
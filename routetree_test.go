@@ -0,0 +1,131 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats"
+)
+
+func TestRouteTreeMatch(t *testing.T) {
+	tree := newRouteTree()
+	for _, url := range []string{"/users/me", "/users/:id", "/users/:id/orders", "/users/*rest", "/a/:x/c", "/a/b/:y"} {
+		tree.insert(&routeEntry{subject: SubscribeURLToNats(GET, url)})
+	}
+	cases := map[string]string{
+		"/users/me":        "/users/me",
+		"/users/42":        "/users/:id",
+		"/users/me/orders": "/users/:id/orders",
+		"/users/42/x":      "/users/*rest",
+		"/a/b/c":           "/a/b/:y",
+		"/a/x/c":           "/a/:x/c",
+	}
+	for path, url := range cases {
+		e := tree.match(URLToNats(GET, path), true)
+		if e == nil || e.subject != SubscribeURLToNats(GET, url) {
+			t.Errorf("Route of %s assertion failed: %v", path, e)
+		}
+	}
+	if tree.match(URLToNats(POST, "/users/me"), true) != nil {
+		t.Error("Method assertion failed")
+	}
+
+	// The removed routes
+	// are pruned.
+	tree.remove(SubscribeURLToNats(GET, "/users/me"))
+	if e := tree.match(URLToNats(GET, "/users/me"), true); e == nil || e.subject != SubscribeURLToNats(GET, "/users/:id") {
+		t.Error("Removed route assertion failed")
+	}
+	for _, url := range []string{"/users/:id", "/users/:id/orders", "/users/*rest", "/a/:x/c", "/a/b/:y"} {
+		tree.remove(SubscribeURLToNats(GET, url))
+	}
+	if len(tree.static) != 0 {
+		t.Error("Pruning assertion failed")
+	}
+}
+
+func TestAmbiguousRoutes(t *testing.T) {
+	cases := []struct {
+		a, b      string
+		ambiguous bool
+	}{
+		{"/users/me", "/users/:id", false},
+		{"/users/:id", "/users/*rest", false},
+		{"/users/:id/orders", "/users/*rest", false},
+		{"/a/:x/c", "/a/b/:y", true},
+		{"/a/b/*rest", "/a/:x/c", true},
+		{"/a/:x/*rest", "/a/b/:y", false},
+		{"/a/:x/c", "/b/:y/c", false},
+		{"/a/:x", "/a/:x/c", false},
+	}
+	for _, c := range cases {
+		if ambiguousRoutes(SubscribeURLToNats(GET, c.a), SubscribeURLToNats(GET, c.b)) != c.ambiguous {
+			t.Errorf("Ambiguity of %s and %s assertion failed", c.a, c.b)
+		}
+	}
+}
+
+func TestRoutePrecedence(t *testing.T) {
+	for _, queueGroup := range []string{"", "precedence"} {
+		clientConn, _ := nats.Connect(nats_url)
+		natsClient, _ := NewNatsClient(clientConn)
+		natsClient.SetQueueGroup(queueGroup)
+		var calls int64
+		natsClient.GET("/precedence/:id", func(c *Context) {
+			atomic.AddInt64(&calls, 1)
+			c.Response.Body = []byte("id")
+		})
+		natsClient.GET("/precedence/me", func(c *Context) {
+			atomic.AddInt64(&calls, 1)
+			c.Response.Body = []byte("me")
+		})
+		clientConn.Flush()
+
+		proxyConn, _ := nats.Connect(nats_url)
+		proxyHandler, _ := NewNatsProxy(proxyConn)
+		for i := 0; i < 20; i++ {
+			for path, expected := range map[string]string{"/precedence/me": "me", "/precedence/42": "id"} {
+				req, _ := http.NewRequest("GET", "http://127.0.0.1:3000"+path, nil)
+				rw := httptest.NewRecorder()
+				proxyHandler.ServeHTTP(rw, req)
+				if rw.Body.String() != expected {
+					t.Errorf("Route of %s in group %q assertion failed: %q", path, queueGroup, rw.Body.String())
+				}
+			}
+		}
+		// Wait for the handlers
+		// possibly answering late.
+		clientConn.Flush()
+		if n := atomic.LoadInt64(&calls); n != 40 {
+			t.Errorf("Exactly one handler assertion failed in group %q: %d", queueGroup, n)
+		}
+		proxyConn.Close()
+		clientConn.Close()
+	}
+}
+
+func TestStrictRoutes(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	logger := &recordingLogger{}
+	natsClient.SetLogger(logger)
+
+	natsClient.GET("/strict/:x/c", func(c *Context) {})
+	if _, err := natsClient.GET("/strict/b/:y", func(c *Context) {}); err != nil {
+		t.Error(err)
+	}
+	if events := logger.snapshot(); len(events) != 1 || events[0].level != "warn" {
+		t.Errorf("Ambiguous route warning assertion failed: %v", events)
+	}
+
+	natsClient.SetStrictRoutes(true)
+	if _, err := natsClient.GET("/strict/b/*rest", func(c *Context) {}); err != ErrRouteConflict {
+		t.Error("Strict route conflict assertion failed")
+	}
+	if _, err := natsClient.GET("/strict/b/c", func(c *Context) {}); err != nil {
+		t.Error("Route more specific than all routes should be accepted")
+	}
+}
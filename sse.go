@@ -0,0 +1,227 @@
+package natsproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// DefaultHeartbeat is the interval
+// of comments written to the idle
+// event stream to keep it open.
+const DefaultHeartbeat = 15 * time.Second
+
+var (
+	// ErrEventStreamNotSupported is returned
+	// by Context.SSE if the request did not
+	// come from NatsProxy.
+	ErrEventStreamNotSupported = errors.New("nats-proxy: event stream not supported")
+
+	// ErrResponseSent is returned if
+	// the response was already sent
+	// to the proxy.
+	ErrResponseSent = errors.New("nats-proxy: response already sent")
+)
+
+// Event is the server-sent event
+// written to the event stream.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// bytes formats the event
+// in text/event-stream format.
+func (e *Event) bytes() []byte {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// EventStream writes the server-sent
+// events to the HTTP response kept
+// open by the proxy. The stream outlives
+// the handler, it is open until the
+// service closes it or the HTTP client
+// disconnects. The events sent from
+// several goroutines are serialized.
+type EventStream struct {
+	// ID identifies the stream, it
+	// could be used to obtain the stream
+	// by NatsClient.EventStream.
+	ID      string
+	conn    *nats.Conn
+	timeout time.Duration
+	mu      sync.Mutex
+}
+
+// SSE turns the response into
+// the event stream. The status code and
+// header must be set before SSE is called,
+// because they are sent to the proxy immediately.
+func (c *Context) SSE() (*EventStream, error) {
+	if c.Request.ResponseStream == "" || c.conn == nil {
+		return nil, ErrEventStreamNotSupported
+	}
+	if c.replied {
+		return nil, ErrResponseSent
+	}
+	c.replied = true
+	c.Response.EventStream = true
+	c.Response.Body = nil
+	if err := c.publishResponse(); err != nil {
+		return nil, err
+	}
+	return newEventStream(c.conn, c.Request.ResponseStream), nil
+}
+
+// LastEventID returns the value of
+// Last-Event-ID header sent by the
+// reconnecting event source.
+func (c *Context) LastEventID() string {
+	return c.HeaderVariable(http.CanonicalHeaderKey("Last-Event-ID"))
+}
+
+// EventStream returns the event
+// stream with given ID, so the events
+// could be sent outside of the handler.
+func (nc *NatsClient) EventStream(id string) *EventStream {
+	return newEventStream(nc.conn, id)
+}
+
+func newEventStream(conn *nats.Conn, id string) *EventStream {
+	return &EventStream{
+		ID:      id,
+		conn:    conn,
+		timeout: DefaultChunkTimeout,
+	}
+}
+
+// Send writes the event to the stream.
+// The error is returned if the stream
+// was closed, e.g. the client disconnected.
+// The calls are serialized per EventStream,
+// the streams obtained separately by
+// NatsClient.EventStream for the same ID
+// are not, so their concurrent events
+// could be refused by ErrStreamBusy.
+func (es *EventStream) Send(event Event) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	_, err := requestChunk(es.conn, es.ID, &Chunk{Data: event.bytes()}, es.timeout)
+	return err
+}
+
+// SendJSON writes the event with
+// the data serialized to JSON.
+func (es *EventStream) SendJSON(event string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return es.Send(Event{Event: event, Data: string(data)})
+}
+
+// Close ends the event stream
+// and the HTTP response.
+func (es *EventStream) Close() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	_, err := requestChunk(es.conn, es.ID, &Chunk{EOF: true}, es.timeout)
+	return err
+}
+
+// OnClose registers the handler
+// called when the stream ends, because
+// the HTTP client disconnected, the proxy
+// closed the stream or the service
+// closed it by Close.
+func (es *EventStream) OnClose(handler func()) error {
+	sub, err := es.conn.Subscribe(closedSubject(es.ID), func(m *nats.Msg) {
+		handler()
+	})
+	if err != nil {
+		return err
+	}
+	return sub.AutoUnsubscribe(1)
+}
+
+func closedSubject(streamID string) string {
+	return strings.TrimSuffix(streamID, stream_RES) + stream_CLOSED
+}
+
+// SetEventStreamHeartbeat sets the interval
+// of comments written to the idle event
+// stream. Zero interval disables the heartbeat.
+func (np *NatsProxy) SetEventStreamHeartbeat(interval time.Duration) {
+	np.heartbeat = interval
+}
+
+// writeEventStream keeps the HTTP response
+// open and forwards the events published
+// by the service until the service closes
+// the stream. The service is notified
+// when the stream ends for any reason.
+func (np *NatsProxy) writeEventStream(rw http.ResponseWriter, req *http.Request, response *Response, s *proxyStream, requestID string) {
+	copyHeader(response.Header, rw.Header())
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(int(response.StatusCode))
+	flusher, _ := rw.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	defer np.notifyClosed(s)
+
+	var heartbeat <-chan time.Time
+	if np.heartbeat > 0 {
+		ticker := time.NewTicker(np.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case m := <-s.chunks:
+			if eof, ok := np.forwardChunk(rw, flusher, m, requestID); eof || !ok {
+				return
+			}
+		case <-heartbeat:
+			if _, err := rw.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (np *NatsProxy) notifyClosed(s *proxyStream) {
+	np.conn.Publish(np.streams.subject(s, stream_CLOSED), nil)
+}
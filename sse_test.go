@@ -0,0 +1,276 @@
+package natsproxy
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+func TestEventFormat(t *testing.T) {
+	event := Event{
+		ID:    "42",
+		Event: "update",
+		Data:  "first\nsecond",
+		Retry: 3 * time.Second,
+	}
+	expected := "id: 42\nevent: update\nretry: 3000\ndata: first\ndata: second\n\n"
+	if string(event.bytes()) != expected {
+		t.Errorf("Event format assertion failed: %q", event.bytes())
+	}
+
+	event = Event{Data: "ping"}
+	if string(event.bytes()) != "data: ping\n\n" {
+		t.Errorf("Data only event assertion failed: %q", event.bytes())
+	}
+}
+
+func TestSSEWithoutProxy(t *testing.T) {
+	c := newContext(nil, NewResponse(), NewRequest())
+	if _, err := c.SSE(); err != ErrEventStreamNotSupported {
+		t.Error("Unsupported event stream assertion failed")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	streams := make(chan *EventStream, 1)
+	closed := make(chan struct{})
+	var lastEventID string
+	natsClient.GET("/events", func(c *Context) {
+		lastEventID = c.LastEventID()
+		es, err := c.SSE()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		es.OnClose(func() {
+			close(closed)
+		})
+		streams <- es
+	})
+	clientConn.Flush()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("Content type assertion failed")
+	}
+	if lastEventID != "7" {
+		t.Error("Last event ID assertion failed")
+	}
+
+	// The events are sent after
+	// the handler returned.
+	es := natsClient.EventStream((<-streams).ID)
+	reader := bufio.NewReader(resp.Body)
+	for i, id := range []string{"8", "9"} {
+		if err := es.Send(Event{ID: id, Data: "tick"}); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		if len(lines) != 2 || lines[0] != "id: "+id || lines[1] != "data: tick" {
+			t.Errorf("Event %d assertion failed: %v", i, lines)
+		}
+	}
+
+	// Disconnect of the HTTP
+	// client closes the stream.
+	resp.Body.Close()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close notification assertion failed")
+	}
+	if err := es.Send(Event{Data: "tick"}); err == nil {
+		t.Error("Send to closed stream assertion failed")
+	}
+}
+
+func TestEventStreamClosedByService(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	streams := make(chan *EventStream, 1)
+	closed := make(chan struct{})
+	natsClient.GET("/events/closed/by/service", func(c *Context) {
+		es, err := c.SSE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams <- es
+	})
+	clientConn.Flush()
+
+	resp, err := http.Get(server.URL + "/events/closed/by/service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The first send subscribes the
+	// replies of the client connection.
+	es := <-streams
+	if err := es.Send(Event{Data: "tick"}); err != nil {
+		t.Fatal(err)
+	}
+	subscriptions := clientConn.NumSubscriptions()
+	if err := es.OnClose(func() { close(closed) }); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Flush()
+	if clientConn.NumSubscriptions() != subscriptions+1 {
+		t.Fatal("OnClose subscription assertion failed")
+	}
+	if err := es.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close notification assertion failed")
+	}
+	// The subscription is removed
+	// after the handler returns.
+	deadline := time.Now().Add(2 * time.Second)
+	for clientConn.NumSubscriptions() != subscriptions && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := clientConn.NumSubscriptions(); n != subscriptions {
+		t.Errorf("OnClose subscription leaked: %d", n)
+	}
+}
+
+func TestPlainResponseAfterEventStream(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	natsClient.GET("/pooled/events", func(c *Context) {
+		es, err := c.SSE()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		es.Send(Event{Data: "tick"})
+		es.Close()
+	})
+	natsClient.GET("/pooled/json", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	// The pooled response of the event
+	// stream must not turn the next
+	// reply into the event stream.
+	client := &http.Client{Timeout: 2 * time.Second}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL + "/pooled/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		resp, err = client.Get(server.URL + "/pooled/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") || string(body) != `"OK"` {
+			t.Fatalf("Plain response assertion failed: %s %q", resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestEventStreamConcurrentSend(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	streams := make(chan *EventStream, 1)
+	natsClient.GET("/events/concurrent", func(c *Context) {
+		es, err := c.SSE()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		streams <- es
+	})
+	clientConn.Flush()
+
+	resp, err := http.Get(server.URL + "/events/concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	es := <-streams
+
+	// More senders than the
+	// proxy buffers chunks.
+	const senders = 64
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			errs <- es.Send(Event{Data: "tick"})
+		}()
+	}
+	for i := 0; i < senders; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	es.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if n := strings.Count(string(body), "data: tick\n"); n != senders {
+		t.Errorf("Received events assertion failed: %d", n)
+	}
+}
@@ -0,0 +1,479 @@
+package natsproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// DefaultChunkSize is the size of
+	// body chunks sent over NATS if the
+	// body is streamed. The request body
+	// streaming is enabled by SetChunkSize.
+	DefaultChunkSize = 256 << 10
+
+	// DefaultChunkTimeout is the time
+	// the client waits for the single
+	// chunk of streamed body or for its
+	// acknowledgement.
+	DefaultChunkTimeout = 10 * time.Second
+
+	// Prefix of the subjects
+	// for streaming the bodies
+	// between proxy and client.
+	stream_PREFIX = "_NPSTREAM."
+
+	// Suffixes of stream subjects
+	// for pulling the request body,
+	// pushing the response body and
+	// notifying the closed stream.
+	stream_REQ    = ".req"
+	stream_RES    = ".res"
+	stream_CLOSED = ".closed"
+)
+
+var (
+	// ErrStreamClosed is returned if
+	// the stream of the body was closed
+	// by the other side.
+	ErrStreamClosed = errors.New("nats-proxy: stream closed")
+
+	// ErrStreamBusy is returned if the
+	// proxy has no room for the chunk, e.g.
+	// the chunks are sent concurrently faster
+	// than the HTTP client reads them.
+	ErrStreamBusy = errors.New("nats-proxy: stream busy")
+)
+
+// proxyStream carries the streamed
+// request and response body of the single
+// HTTP request through NatsProxy.
+type proxyStream struct {
+	id     string
+	pulls  chan *nats.Msg
+	chunks chan *nats.Msg
+	done   chan struct{}
+}
+
+// streamRegistry dispatches the stream
+// messages received on the single proxy
+// subscription to the open streams.
+type streamRegistry struct {
+	mu      sync.RWMutex
+	prefix  string
+	streams map[string]*proxyStream
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{
+		prefix:  stream_PREFIX + nuid.Next() + ".",
+		streams: make(map[string]*proxyStream),
+	}
+}
+
+func (sr *streamRegistry) open() *proxyStream {
+	s := &proxyStream{
+		id:     nuid.Next(),
+		pulls:  make(chan *nats.Msg, 1),
+		chunks: make(chan *nats.Msg, 16),
+		done:   make(chan struct{}),
+	}
+	sr.mu.Lock()
+	sr.streams[s.id] = s
+	sr.mu.Unlock()
+	return s
+}
+
+func (sr *streamRegistry) close(s *proxyStream) {
+	sr.mu.Lock()
+	delete(sr.streams, s.id)
+	sr.mu.Unlock()
+	close(s.done)
+}
+
+func (sr *streamRegistry) subject(s *proxyStream, suffix string) string {
+	return sr.prefix + s.id + suffix
+}
+
+// dispatchStream routes the message
+// to the stream given by the subject.
+// All streams share the subscription,
+// so the message not fitting the buffer
+// of the stream is refused by ErrStreamBusy
+// rather than blocking the other streams.
+func (np *NatsProxy) dispatchStream(m *nats.Msg) {
+	sr := np.streams
+	tokens := strings.Split(strings.TrimPrefix(m.Subject, sr.prefix), ".")
+	if len(tokens) != 2 {
+		return
+	}
+	sr.mu.RLock()
+	s, ok := sr.streams[tokens[0]]
+	sr.mu.RUnlock()
+	if !ok {
+		np.replyChunk(m.Reply, &Chunk{Error: ErrStreamClosed.Error()})
+		return
+	}
+
+	var ch chan *nats.Msg
+	switch "." + tokens[1] {
+	case stream_REQ:
+		ch = s.pulls
+	case stream_RES:
+		ch = s.chunks
+	default:
+		return
+	}
+	select {
+	case ch <- m:
+	case <-s.done:
+		np.replyChunk(m.Reply, &Chunk{Error: ErrStreamClosed.Error()})
+	default:
+		np.replyChunk(m.Reply, &Chunk{Error: ErrStreamBusy.Error()})
+	}
+}
+
+// SetChunkSize enables the streaming of
+// the request body in chunks of size.
+// The request bodies of unknown length or
+// longer than size are streamed to the service,
+// which must read them by BodyReader.
+// Zero size, the default, disables the streaming.
+func (np *NatsProxy) SetChunkSize(size int) {
+	np.chunkSize = size
+}
+
+func (np *NatsProxy) streamsBody(req *http.Request) bool {
+	if np.chunkSize <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	return req.ContentLength < 0 || req.ContentLength > int64(np.chunkSize)
+}
+
+// serveBody answers the pulls of the
+// service with the chunks of the request body
+// until the body is read or the stream closed.
+func (np *NatsProxy) serveBody(s *proxyStream, body io.ReadCloser) {
+	defer body.Close()
+	buf := make([]byte, np.chunkSize)
+	for {
+		select {
+		case <-s.done:
+			return
+		case m := <-s.pulls:
+			n, err := io.ReadFull(body, buf)
+			chunk := &Chunk{Data: buf[:n]}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				chunk.EOF = true
+			} else if err != nil {
+				chunk.Error = err.Error()
+			}
+			np.replyChunk(m.Reply, chunk)
+			if chunk.EOF || chunk.Error != "" {
+				return
+			}
+		}
+	}
+}
+
+// writeStreamedResponse writes the
+// response header and then the body chunks
+// pushed by the service until the EOF chunk.
+// Each chunk is acknowledged after it is
+// written to the HTTP response.
+func (np *NatsProxy) writeStreamedResponse(rw http.ResponseWriter, req *http.Request, response *Response, s *proxyStream, idleTimeout time.Duration, requestID string) {
+	copyHeader(response.Header, rw.Header())
+	rw.WriteHeader(int(response.StatusCode))
+	flusher, _ := rw.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case m := <-s.chunks:
+			if eof, ok := np.forwardChunk(rw, flusher, m, requestID); eof || !ok {
+				return
+			}
+			idle.Reset(idleTimeout)
+		case <-idle.C:
+			np.logger.Warn("response stream idle timeout", "request_id", requestID)
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// forwardChunk writes the chunk pushed by
+// the service to the HTTP response and
+// acknowledges it. The eof is true for
+// the last chunk of the stream, ok is false
+// if the stream failed.
+func (np *NatsProxy) forwardChunk(rw http.ResponseWriter, flusher http.Flusher, m *nats.Msg, requestID string) (eof bool, ok bool) {
+	chunk := &Chunk{}
+	if err := proto.Unmarshal(m.Data, chunk); err != nil {
+		np.logger.Error("cannot decode response chunk", "request_id", requestID, "error", err)
+		np.replyChunk(m.Reply, &Chunk{Error: err.Error()})
+		return false, false
+	}
+	if chunk.Error != "" {
+		np.logger.Warn("response stream failed", "request_id", requestID, "error", chunk.Error)
+		return false, false
+	}
+	if len(chunk.Data) > 0 {
+		if _, err := rw.Write(chunk.Data); err != nil {
+			np.replyChunk(m.Reply, &Chunk{Error: err.Error()})
+			return false, false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	np.replyChunk(m.Reply, &Chunk{})
+	return chunk.EOF, true
+}
+
+func (np *NatsProxy) replyChunk(reply string, chunk *Chunk) {
+	if reply == "" {
+		return
+	}
+	data, err := proto.Marshal(chunk)
+	if err != nil {
+		np.logger.Error("cannot encode chunk", "error", err)
+		return
+	}
+	np.conn.Publish(reply, data)
+}
+
+// requestChunk sends the chunk
+// message and reads the chunk reply.
+func requestChunk(conn *nats.Conn, subject string, chunk *Chunk, timeout time.Duration) (*Chunk, error) {
+	var data []byte
+	if chunk != nil {
+		var err error
+		if data, err = proto.Marshal(chunk); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := conn.Request(subject, data, timeout)
+	if err != nil {
+		return nil, err
+	}
+	reply := &Chunk{}
+	if err := proto.Unmarshal(msg.Data, reply); err != nil {
+		return nil, err
+	}
+	switch reply.Error {
+	case "":
+		return reply, nil
+	case ErrStreamClosed.Error():
+		return reply, ErrStreamClosed
+	case ErrStreamBusy.Error():
+		return reply, ErrStreamBusy
+	}
+	return reply, errors.New(reply.Error)
+}
+
+// bodyReader reads the request
+// body streamed by the proxy.
+type bodyReader struct {
+	conn    *nats.Conn
+	subject string
+	timeout time.Duration
+	buf     []byte
+	eof     bool
+	err     error
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.eof {
+			return 0, io.EOF
+		}
+		chunk, err := requestChunk(r.conn, r.subject, nil, r.timeout)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		r.buf = chunk.Data
+		r.eof = chunk.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// bodyWriter pushes the response
+// body to the proxy in chunks. The response
+// header is sent with the first write.
+type bodyWriter struct {
+	c         *Context
+	conn      *nats.Conn
+	subject   string
+	chunkSize int
+	timeout   time.Duration
+	started   bool
+	closed    bool
+	err       error
+}
+
+// start sends the response
+// header to the proxy.
+func (w *bodyWriter) start() error {
+	w.started = true
+	w.c.replied = true
+	w.c.Response.Streamed = true
+	w.c.Response.Body = nil
+	return w.c.publishResponse()
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrStreamClosed
+	}
+	if !w.started {
+		if w.err = w.start(); w.err != nil {
+			return 0, w.err
+		}
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.chunkSize {
+			n = w.chunkSize
+		}
+		if _, err := requestChunk(w.conn, w.subject, &Chunk{Data: p[:n]}, w.timeout); err != nil {
+			w.err = err
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush sends the response
+// header if not sent yet.
+func (w *bodyWriter) Flush() error {
+	if w.started || w.err != nil {
+		return w.err
+	}
+	w.err = w.start()
+	return w.err
+}
+
+// Close finishes the
+// response body stream.
+func (w *bodyWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	_, err := requestChunk(w.conn, w.subject, &Chunk{EOF: true}, w.timeout)
+	return err
+}
+
+// fail aborts the
+// response body stream.
+func (w *bodyWriter) fail(reason string) {
+	if w.closed || !w.started {
+		return
+	}
+	w.closed = true
+	data, _ := proto.Marshal(&Chunk{Error: reason})
+	w.conn.Publish(w.subject, data)
+}
+
+// bufferWriter writes the response
+// body to the Response if the requester
+// does not support streaming.
+type bufferWriter struct {
+	c *Context
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.c.Response.Body = append(w.c.Response.Body, p...)
+	return len(p), nil
+}
+
+// BodyReader returns the reader
+// of the request body. The body streamed
+// by the proxy is read chunk by chunk.
+func (c *Context) BodyReader() io.Reader {
+	if c.Request.BodyStream == "" || c.conn == nil {
+		return bytes.NewReader(c.Request.Body)
+	}
+	if c.bodyReader == nil {
+		c.bodyReader = &bodyReader{
+			conn:    c.conn,
+			subject: c.Request.BodyStream,
+			timeout: DefaultChunkTimeout,
+		}
+	}
+	return c.bodyReader
+}
+
+// BodyWriter returns the writer of the
+// response body. The status code and header
+// must be set before the first write, which
+// sends them to the proxy. The written
+// data are then streamed to the HTTP
+// response as they arrive.
+func (c *Context) BodyWriter() io.Writer {
+	if c.Request.ResponseStream == "" || c.conn == nil {
+		return &bufferWriter{c}
+	}
+	if c.bodyWriter == nil {
+		c.bodyWriter = &bodyWriter{
+			c:         c,
+			conn:      c.conn,
+			subject:   c.Request.ResponseStream,
+			chunkSize: DefaultChunkSize,
+			timeout:   DefaultChunkTimeout,
+		}
+	}
+	return c.bodyWriter
+}
+
+// isStreaming returns true if the
+// response header was already
+// streamed to the proxy.
+func (c *Context) isStreaming() bool {
+	return c.bodyWriter != nil && c.bodyWriter.started
+}
+
+// loadBody reads the streamed
+// request body into the Request.
+func (c *Context) loadBody() error {
+	if c.Request.BodyStream == "" || c.conn == nil {
+		return nil
+	}
+	buf := bytes.NewBuffer(c.Request.Body[:0])
+	if _, err := buf.ReadFrom(c.BodyReader()); err != nil {
+		return err
+	}
+	c.Request.Body = buf.Bytes()
+	c.Request.BodyStream = ""
+	return nil
+}
@@ -0,0 +1,229 @@
+package natsproxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+func TestStreamsBody(t *testing.T) {
+	np := &NatsProxy{chunkSize: 10}
+
+	req, _ := http.NewRequest("POST", "/upload", strings.NewReader("short"))
+	if np.streamsBody(req) {
+		t.Error("Short body streamed")
+	}
+
+	req, _ = http.NewRequest("POST", "/upload", strings.NewReader("long enough body"))
+	if !np.streamsBody(req) {
+		t.Error("Long body not streamed")
+	}
+
+	req.ContentLength = -1
+	if !np.streamsBody(req) {
+		t.Error("Body of unknown length not streamed")
+	}
+
+	req, _ = http.NewRequest("GET", "/upload", nil)
+	if np.streamsBody(req) {
+		t.Error("Missing body streamed")
+	}
+
+	np.SetChunkSize(0)
+	req, _ = http.NewRequest("POST", "/upload", strings.NewReader("long enough body"))
+	if np.streamsBody(req) {
+		t.Error("Disabled streaming assertion failed")
+	}
+}
+
+func TestBodyWithoutStream(t *testing.T) {
+	c := newContext(buildParamMap("/test"), NewResponse(), &Request{Body: []byte("inline")})
+	body, _ := ioutil.ReadAll(c.BodyReader())
+	if string(body) != "inline" {
+		t.Error("Inline body reader assertion failed")
+	}
+
+	io.WriteString(c.BodyWriter(), "buffered ")
+	io.WriteString(c.BodyWriter(), "response")
+	if string(c.Response.Body) != "buffered response" || c.isStreaming() {
+		t.Error("Buffered body writer assertion failed")
+	}
+}
+
+func TestStreamedBodies(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetChunkSize(64 << 10)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	// Echo the sha1 of the
+	// streamed upload.
+	natsClient.POST("/upload", func(c *Context) {
+		if c.Request.BodyStream == "" {
+			t.Error("Upload not streamed")
+		}
+		h := sha1.New()
+		if _, err := io.Copy(h, c.BodyReader()); err != nil {
+			t.Error(err)
+		}
+		c.Response.Body = h.Sum(nil)
+	})
+
+	// Stream the download
+	// larger than NATS payload.
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1<<17)
+	natsClient.GET("/download", func(c *Context) {
+		c.Response.GetHeader().Set("Content-Type", "application/octet-stream")
+		w := c.BodyWriter()
+		for i := 0; i < len(payload); i += 100000 {
+			end := i + 100000
+			if end > len(payload) {
+				end = len(payload)
+			}
+			if _, err := w.Write(payload[i:end]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	clientConn.Flush()
+
+	resp, err := http.Post(server.URL+"/upload", "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	expected := sha1.Sum(payload)
+	if !bytes.Equal(sum, expected[:]) {
+		t.Error("Streamed upload assertion failed")
+	}
+
+	resp, err = http.Get(server.URL + "/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Error("Streamed header assertion failed")
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Streamed download assertion failed, got %d bytes", len(body))
+	}
+}
+
+func TestChunkedBodyNotStreamedByDefault(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	// The handler reads
+	// the body directly.
+	natsClient.POST("/chunked/direct", func(c *Context) {
+		c.Response.Body = []byte(strconv.Itoa(len(c.Request.Body)))
+	})
+	clientConn.Flush()
+
+	body := bytes.Repeat([]byte("x"), 300<<10)
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:3000/chunked/direct", bytes.NewReader(body))
+	req.ContentLength = -1
+	rw := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rw, req)
+	if rw.Body.String() != strconv.Itoa(len(body)) {
+		t.Errorf("Direct body read assertion failed: %s", rw.Body.String())
+	}
+}
+
+func TestPlainResponseAfterStreamed(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	natsClient.GET("/pooled/streamed", func(c *Context) {
+		io.WriteString(c.BodyWriter(), "streamed")
+	})
+	natsClient.GET("/pooled/plain", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	// The pooled response of the
+	// streamed reply must not make
+	// the next reply streamed.
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/pooled/streamed", nil)
+		rw := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rw, req)
+		if rw.Body.String() != "streamed" {
+			t.Fatalf("Streamed response assertion failed: %q", rw.Body.String())
+		}
+
+		req, _ = http.NewRequest("GET", "http://127.0.0.1:3000/pooled/plain", nil)
+		rw = httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rw, req)
+		if rw.Code != 200 || rw.Body.String() != `"OK"` {
+			t.Fatalf("Plain response assertion failed: %d %q", rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestStreamBusy(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyConn.Flush()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+
+	// The chunks of the stream
+	// nobody reads fill its buffer.
+	busy := proxyHandler.streams.open()
+	defer proxyHandler.streams.close(busy)
+	subject := proxyHandler.streams.subject(busy, stream_RES)
+	for i := 0; i < cap(busy.chunks); i++ {
+		proxyHandler.dispatchStream(&nats.Msg{Subject: subject})
+	}
+	if _, err := requestChunk(clientConn, subject, &Chunk{Data: []byte("x")}, time.Second); err != ErrStreamBusy {
+		t.Errorf("Busy stream assertion failed: %v", err)
+	}
+
+	// The other streams
+	// are still served.
+	other := proxyHandler.streams.open()
+	go func() {
+		m := <-other.chunks
+		proxyHandler.replyChunk(m.Reply, &Chunk{})
+	}()
+	if _, err := requestChunk(clientConn, proxyHandler.streams.subject(other, stream_RES), &Chunk{}, time.Second); err != nil {
+		t.Error(err)
+	}
+	proxyHandler.streams.close(other)
+	if _, err := requestChunk(clientConn, proxyHandler.streams.subject(other, stream_RES), &Chunk{}, time.Second); err != ErrStreamClosed {
+		t.Errorf("Closed stream assertion failed: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package natsproxy
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// DefaultDrainTimeout is the time
+// Close waits for in-flight handlers.
+const DefaultDrainTimeout = 30 * time.Second
+
+var (
+	// ErrNatsClientClosed is returned
+	// if the route is subscribed on
+	// closed or draining NatsClient.
+	ErrNatsClientClosed = fmt.Errorf("nats-proxy: client closed")
+
+	// ErrRouteNotFound is returned
+	// by Unsubscribe if no route is
+	// subscribed for method and url.
+	ErrRouteNotFound = fmt.Errorf("nats-proxy: route not found")
+
+	// ErrRouteExists is returned
+	// if the route for the method and url
+	// is already subscribed.
+	ErrRouteExists = fmt.Errorf("nats-proxy: route already subscribed")
+
+	// ErrDrainTimeout is returned
+	// if the in-flight handlers did not
+	// finish within the drain timeout.
+	ErrDrainTimeout = fmt.Errorf("nats-proxy: drain timeout")
+)
+
+// Subscription is the handle of
+// the route subscribed by NatsClient.
+type Subscription struct {
+	Method  string
+	URL     string
+	Subject string
+	Group   string
+	sub     *nats.Subscription
+	client  *NatsClient
+
+	params      map[string]int
+	constraints pathConstraints
+	group       *RouterGroup
+	handlers    NatsHandlers
+}
+
+// Unsubscribe removes the route
+// from the client. The requests already
+// delivered to the client are processed.
+func (s *Subscription) Unsubscribe() error {
+	return s.client.Unsubscribe(s.Method, s.URL)
+}
+
+// Unsubscribe removes the route subscribed
+// for given method and url. The subscription is
+// drained, so the requests already delivered
+// to the client are still processed.
+func (nc *NatsClient) Unsubscribe(method, url string) error {
+	subject := SubscribeURLToNats(method, url)
+	nc.mu.Lock()
+	s, ok := nc.routes[subject]
+	if ok {
+		delete(nc.routes, subject)
+		nc.routeTree.remove(subject)
+		nc.removeAutoRoutes(url)
+	}
+	nc.mu.Unlock()
+	if !ok {
+		return ErrRouteNotFound
+	}
+	return s.sub.Drain()
+}
+
+// RemoveWebsocketHandler unsubscribes
+// all handlers registered by HandleWebsocket
+// for the websocketID.
+func (nc *NatsClient) RemoveWebsocketHandler(websocketID string) error {
+	nc.mu.Lock()
+	subs := nc.wsSubs[websocketID]
+	delete(nc.wsSubs, websocketID)
+	delete(nc.wsEvents, websocketID)
+	nc.mu.Unlock()
+
+	var outerError error
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil && err != nats.ErrBadSubscription {
+			outerError = err
+		}
+	}
+	return outerError
+}
+
+// Drain stops receiving of new requests
+// on all route and websocket subscriptions
+// and waits until the in-flight handlers
+// finish or the timeout expires. If the
+// drain of some subscription fails, the
+// rest is still drained and the first
+// error returned after the wait.
+// The client cannot be used after Drain.
+// The underlying NATS connection
+// is not closed.
+func (nc *NatsClient) Drain(timeout time.Duration) error {
+	nc.mu.Lock()
+	nc.closed = true
+	subs := make([]*nats.Subscription, 0, len(nc.routes))
+	for _, s := range nc.routes {
+		subs = append(subs, s.sub)
+	}
+	for _, auto := range nc.autoRoutes {
+		subs = append(subs, auto)
+	}
+	for _, wsSubs := range nc.wsSubs {
+		subs = append(subs, wsSubs...)
+	}
+	nc.routes = make(map[string]*Subscription)
+	nc.autoRoutes = make(map[string]*nats.Subscription)
+	nc.routeTree = newRouteTree()
+	nc.autoTree = newRouteTree()
+	nc.wsSubs = make(map[string][]*nats.Subscription)
+	nc.wsEvents = make(map[string][]WebsocketEventHandler)
+	nc.mu.Unlock()
+
+	var drainErr error
+	draining := make([]*nats.Subscription, 0, len(subs))
+	for _, sub := range subs {
+		err := sub.Drain()
+		if err == nil {
+			draining = append(draining, sub)
+		} else if err != nats.ErrBadSubscription && drainErr == nil {
+			drainErr = err
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !drained(draining) || atomic.LoadInt64(&nc.inFlight) > 0 {
+		if time.Now().After(deadline) {
+			if drainErr != nil {
+				return drainErr
+			}
+			return ErrDrainTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return drainErr
+}
+
+// Close gracefully drains the client
+// with DefaultDrainTimeout.
+func (nc *NatsClient) Close() error {
+	return nc.Drain(DefaultDrainTimeout)
+}
+
+func drained(subs []*nats.Subscription) bool {
+	for _, sub := range subs {
+		if sub.IsValid() {
+			return false
+		}
+	}
+	return true
+}
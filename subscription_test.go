@@ -0,0 +1,122 @@
+package natsproxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats"
+)
+
+func TestUnsubscribe(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	natsClient, _ := NewNatsClient(clientConn)
+	defer clientConn.Close()
+
+	sub, err := natsClient.GET("/unsubscribe", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Subject != "GET:.unsubscribe" {
+		t.Error("Subscription subject assertion failed")
+	}
+
+	if _, err := natsClient.GET("/unsubscribe", func(c *Context) {}); err != ErrRouteExists {
+		t.Error("Duplicate route assertion failed")
+	}
+	clientConn.Flush()
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{})
+	if _, err := testClient.Request("GET:.unsubscribe", data, time.Second); err != nil {
+		t.Error("Did not get response")
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Error(err)
+	}
+	clientConn.Flush()
+	if _, err := testClient.Request("GET:.unsubscribe", data, 100*time.Millisecond); err == nil {
+		t.Error("Unsubscribed route responded")
+	}
+
+	if err := natsClient.Unsubscribe(GET, "/unsubscribe"); err != ErrRouteNotFound {
+		t.Error("Missing route assertion failed")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	natsClient, _ := NewNatsClient(clientConn)
+	defer clientConn.Close()
+
+	started := make(chan struct{})
+	natsClient.GET("/drain", func(c *Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{})
+
+	replied := make(chan error, 1)
+	go func() {
+		_, err := testClient.Request("GET:.drain", data, time.Second)
+		replied <- err
+	}()
+
+	<-started
+	if err := natsClient.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The in-flight request
+	// must be answered.
+	if err := <-replied; err != nil {
+		t.Error("In-flight request dropped")
+	}
+
+	if _, err := natsClient.GET("/drain/again", func(c *Context) {}); err != ErrNatsClientClosed {
+		t.Error("Closed client assertion failed")
+	}
+}
+
+func TestDrainError(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	natsClient, _ := NewNatsClient(clientConn)
+	defer clientConn.Close()
+
+	started := make(chan struct{})
+	var finished int32
+	natsClient.GET("/drain/error", func(c *Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+	natsClient.GET("/drain/error/other", func(c *Context) {})
+	clientConn.Flush()
+
+	testClient, _ := nats.Connect(nats_url)
+	defer testClient.Close()
+	data, _ := proto.Marshal(&Request{})
+	testClient.Publish("GET:.drain.error", data)
+
+	// The drain of subscriptions fails
+	// on the closed connection, the
+	// in-flight handler is still awaited.
+	<-started
+	clientConn.Close()
+	if err := natsClient.Drain(time.Second); err != nats.ErrConnectionClosed {
+		t.Errorf("Drain error assertion failed: %v", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("In-flight handler not awaited")
+	}
+}
@@ -0,0 +1,178 @@
+package natsproxy
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultProxyTimeout is the time
+	// the NatsProxy waits for the
+	// response if no other timeout applies.
+	DefaultProxyTimeout = 10 * time.Second
+
+	// DefaultClientTimeout is the time
+	// the NatsClient waits for the response
+	// in Send if no other timeout applies.
+	DefaultClientTimeout = time.Second
+
+	// TimeoutHeader is the default
+	// HTTP header which could override
+	// the timeout for a single request.
+	TimeoutHeader = "X-Request-Timeout"
+)
+
+type routeTimeout struct {
+	regexp  *regexp.Regexp
+	timeout time.Duration
+}
+
+// timeoutPolicy resolves the timeout
+// for the proxied request. The precedence is
+// header override, first matching route and
+// the default timeout.
+type timeoutPolicy struct {
+	mu             sync.RWMutex
+	defaultTimeout time.Duration
+	routes         []routeTimeout
+	header         string
+	maxOverride    time.Duration
+}
+
+func newTimeoutPolicy() *timeoutPolicy {
+	return &timeoutPolicy{
+		defaultTimeout: DefaultProxyTimeout,
+		routes:         make([]routeTimeout, 0),
+		header:         TimeoutHeader,
+	}
+}
+
+func (tp *timeoutPolicy) setDefault(timeout time.Duration) {
+	tp.mu.Lock()
+	tp.defaultTimeout = timeout
+	tp.mu.Unlock()
+}
+
+func (tp *timeoutPolicy) addRoute(urlRegex string, timeout time.Duration) error {
+	rgxp, err := regexp.Compile(urlRegex)
+	if err != nil {
+		return err
+	}
+	tp.mu.Lock()
+	tp.routes = append(tp.routes, routeTimeout{rgxp, timeout})
+	tp.mu.Unlock()
+	return nil
+}
+
+func (tp *timeoutPolicy) setOverride(header string, max time.Duration) {
+	tp.mu.Lock()
+	tp.header = header
+	tp.maxOverride = max
+	tp.mu.Unlock()
+}
+
+// resolve returns the timeout for
+// given path and request header.
+func (tp *timeoutPolicy) resolve(path string, header http.Header) time.Duration {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+
+	timeout := tp.defaultTimeout
+	for _, rt := range tp.routes {
+		if rt.regexp.MatchString(path) {
+			timeout = rt.timeout
+			break
+		}
+	}
+
+	// The header can only override the
+	// timeout if the maximum is set.
+	headerVal := header.Get(tp.header)
+	if tp.maxOverride <= 0 || headerVal == "" {
+		return timeout
+	}
+	if override, err := parseTimeout(headerVal); err == nil && override > 0 {
+		if override > tp.maxOverride {
+			override = tp.maxOverride
+		}
+		return override
+	}
+	return timeout
+}
+
+// parseTimeout parses the timeout
+// either in time.Duration format ("1.5s", "250ms")
+// or as plain number of seconds.
+func parseTimeout(val string) (time.Duration, error) {
+	val = strings.TrimSpace(val)
+	if secs, err := strconv.ParseFloat(val, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(val)
+}
+
+// SetTimeout sets the default
+// timeout of the proxy for
+// waiting on service response.
+func (np *NatsProxy) SetTimeout(timeout time.Duration) {
+	np.timeouts.setDefault(timeout)
+}
+
+// AddTimeout sets the timeout for
+// all requests with path matching
+// the urlRegex. The routes are evaluated
+// in order they were added, the first
+// match wins.
+func (np *NatsProxy) AddTimeout(urlRegex string, timeout time.Duration) error {
+	return np.timeouts.addRoute(urlRegex, timeout)
+}
+
+// AllowTimeoutOverride enables the
+// incoming HTTP header to override
+// the timeout for single request.
+// The value of header is clamped to max.
+// Empty header means TimeoutHeader.
+func (np *NatsProxy) AllowTimeoutOverride(header string, max time.Duration) {
+	if header == "" {
+		header = TimeoutHeader
+	}
+	np.timeouts.setOverride(header, max)
+}
+
+// SendOption configures the
+// single request sent by NatsClient.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	timeout time.Duration
+	span    *Span
+}
+
+// WithTimeout overrides the
+// timeout of NatsClient for
+// single Send call.
+func WithTimeout(timeout time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.timeout = timeout
+	}
+}
+
+// SetTimeout sets the default
+// timeout for Send calls.
+func (nc *NatsClient) SetTimeout(timeout time.Duration) {
+	nc.timeout = timeout
+}
+
+func (nc *NatsClient) buildSendOptions(opts []SendOption) *sendOptions {
+	o := &sendOptions{
+		timeout: nc.timeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
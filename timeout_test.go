@@ -0,0 +1,81 @@
+package natsproxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeoutPolicy(t *testing.T) {
+	tp := newTimeoutPolicy()
+	header := http.Header{}
+
+	if tp.resolve("/health", header) != DefaultProxyTimeout {
+		t.Error("Default timeout assertion failed")
+	}
+
+	tp.addRoute("^/health", 100*time.Millisecond)
+	tp.addRoute("^/reports/.*", 30*time.Second)
+	tp.addRoute("^/reports/daily", time.Minute)
+
+	if tp.resolve("/health", header) != 100*time.Millisecond {
+		t.Error("Route timeout assertion failed")
+	}
+
+	// First registered
+	// route wins
+	if tp.resolve("/reports/daily", header) != 30*time.Second {
+		t.Error("Route order assertion failed")
+	}
+
+	// Header is ignored
+	// if override not allowed
+	header.Set(TimeoutHeader, "5s")
+	if tp.resolve("/health", header) != 100*time.Millisecond {
+		t.Error("Disabled override assertion failed")
+	}
+
+	tp.setOverride(TimeoutHeader, 20*time.Second)
+	if tp.resolve("/health", header) != 5*time.Second {
+		t.Error("Header override assertion failed")
+	}
+
+	header.Set(TimeoutHeader, "120")
+	if tp.resolve("/health", header) != 20*time.Second {
+		t.Error("Header override clamp assertion failed")
+	}
+
+	header.Set(TimeoutHeader, "nonsense")
+	if tp.resolve("/health", header) != 100*time.Millisecond {
+		t.Error("Invalid header override assertion failed")
+	}
+
+	if err := tp.addRoute("[", time.Second); err == nil {
+		t.Error("Invalid regexp assertion failed")
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	cases := map[string]time.Duration{
+		"1":     time.Second,
+		"0.5":   500 * time.Millisecond,
+		"250ms": 250 * time.Millisecond,
+		" 2s ":  2 * time.Second,
+	}
+	for in, expected := range cases {
+		if out, err := parseTimeout(in); err != nil || out != expected {
+			t.Errorf("Parse timeout %q assertion failed: %v", in, out)
+		}
+	}
+}
+
+func TestClientSendOptions(t *testing.T) {
+	nc := &NatsClient{timeout: DefaultClientTimeout}
+	if nc.buildSendOptions(nil).timeout != DefaultClientTimeout {
+		t.Error("Default client timeout assertion failed")
+	}
+	opts := nc.buildSendOptions([]SendOption{WithTimeout(time.Minute)})
+	if opts.timeout != time.Minute {
+		t.Error("Timeout option assertion failed")
+	}
+}
@@ -0,0 +1,202 @@
+package natsproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TraceparentHeader and TracestateHeader
+	// carry the W3C trace context
+	// of the HTTP request.
+	TraceparentHeader = "Traceparent"
+	TracestateHeader  = "Tracestate"
+
+	traceVersion = "00"
+	flagSampled  = "01"
+	flagNone     = "00"
+)
+
+// Exporter receives the
+// finished spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Span is the timed operation
+// of the traced request.
+type Span struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Sampled    bool
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+
+	exporter Exporter
+}
+
+// startSpan starts the span continuing
+// the trace of the traceparent. The new
+// trace is started if the traceparent
+// is empty or malformed.
+func startSpan(name, traceparent string, exporter Exporter) *Span {
+	span := &Span{
+		Name:       name,
+		SpanID:     randomHex(8),
+		Sampled:    true,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		exporter:   exporter,
+	}
+	if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+		span.TraceID = traceID
+		span.ParentID = parentID
+		span.Sampled = sampled
+	} else {
+		span.TraceID = randomHex(16)
+	}
+	return span
+}
+
+// SetAttribute sets the
+// attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s != nil {
+		s.Attributes[key] = value
+	}
+}
+
+// Traceparent formats the span
+// as W3C traceparent value,
+// so it is the parent of the
+// spans of the callee.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := flagNone
+	if s.Sampled {
+		flags = flagSampled
+	}
+	return traceVersion + "-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// finish ends the span and
+// exports it if it is sampled.
+func (s *Span) finish() {
+	s.End = time.Now()
+	if s.exporter != nil && s.Sampled {
+		s.exporter.Export(s)
+	}
+}
+
+func parseTraceparent(traceparent string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false, false
+	}
+	if parts[0] == traceVersion && len(parts) != 4 {
+		return "", "", false, false
+	}
+	traceID, parentID = parts[1], parts[2]
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(parts[3], 2) {
+		return "", "", false, false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InMemoryExporter keeps the exported
+// spans in memory, it is intended
+// for the tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewInMemoryExporter creates
+// the empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Export stores the span.
+func (e *InMemoryExporter) Export(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the exported
+// spans in order of export.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make([]*Span, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// Reset drops the
+// exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// SetExporter sets the exporter
+// of the spans of the proxied requests.
+// The trace context is propagated
+// to the services also without exporter.
+func (np *NatsProxy) SetExporter(exporter Exporter) {
+	np.exporter = exporter
+}
+
+// SetExporter sets the exporter of
+// the spans of handlers and Send calls.
+func (nc *NatsClient) SetExporter(exporter Exporter) {
+	nc.exporter = exporter
+}
+
+// Span returns the span of the
+// handler, it could be passed to Send
+// by WithSpan to continue the trace.
+func (c *Context) Span() *Span {
+	return c.span
+}
+
+// WithSpan sets the parent span
+// of the request sent by Send.
+func WithSpan(span *Span) SendOption {
+	return func(o *sendOptions) {
+		o.span = span
+	}
+}
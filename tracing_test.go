@@ -0,0 +1,110 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7" || !sampled {
+		t.Error("Traceparent assertion failed")
+	}
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, traceparent := range invalid {
+		if _, _, _, ok := parseTraceparent(traceparent); ok {
+			t.Errorf("Invalid traceparent %q assertion failed", traceparent)
+		}
+	}
+	// Future versions could
+	// have more fields.
+	if _, _, _, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok {
+		t.Error("Future version assertion failed")
+	}
+}
+
+func TestSpanTraceparent(t *testing.T) {
+	root := startSpan("root", "", nil)
+	if _, _, _, ok := parseTraceparent(root.Traceparent()); !ok {
+		t.Errorf("Root traceparent assertion failed: %s", root.Traceparent())
+	}
+	child := startSpan("child", root.Traceparent(), nil)
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Error("Child span assertion failed")
+	}
+	unsampled := startSpan("unsampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", nil)
+	if unsampled.Sampled {
+		t.Error("Sampled flag assertion failed")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := NewInMemoryExporter()
+
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetExporter(exporter)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	natsClient.SetExporter(exporter)
+	natsClient.GET("/traced/front", func(c *Context) {
+		if _, err := natsClient.Send(GET, "/traced/back", NewRequest(), WithSpan(c.Span())); err != nil {
+			t.Error(err)
+		}
+		c.JSON(200, "OK")
+	})
+	natsClient.GET("/traced/back", func(c *Context) {
+		c.JSON(200, "OK")
+	})
+	clientConn.Flush()
+
+	req, _ := http.NewRequest("GET", server.URL+"/traced/front", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	spans := make(map[string]*Span)
+	for _, span := range exporter.Spans() {
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Trace ID of %s assertion failed", span.Name)
+		}
+		spans[span.Name] = span
+	}
+	proxySpan := spans["HTTP GET"]
+	front := spans["GET /traced/front"]
+	send := spans["SEND GET:.traced.back"]
+	back := spans["GET /traced/back"]
+	if proxySpan == nil || front == nil || send == nil || back == nil {
+		t.Fatalf("Spans assertion failed: %v", spans)
+	}
+	if proxySpan.ParentID != "00f067aa0ba902b7" ||
+		front.ParentID != proxySpan.SpanID ||
+		send.ParentID != front.SpanID ||
+		back.ParentID != send.SpanID {
+		t.Error("Span parent assertion failed")
+	}
+	if proxySpan.Attributes["http.status_code"] != "200" {
+		t.Error("Status attribute assertion failed")
+	}
+}
@@ -1,16 +1,23 @@
 package natsproxy
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
 	"strings"
 
 	"github.com/nats-io/nats"
 )
 
 var (
-	pathrgxp = regexp.MustCompile(":[A-z,0-9,$,-,_,.,+,!,*,',(,),\\,]{1,}")
+	// ErrInvalidSubject is returned if the
+	// subject is not built by URLToNats.
+	ErrInvalidSubject = errors.New("natsproxy: invalid subject")
+
+	// ErrInvalidRoute is returned if the
+	// catch-all parameter of the route
+	// is not the last segment.
+	ErrInvalidRoute = errors.New("natsproxy: catch-all parameter must be the last segment")
 )
 
 const (
@@ -21,25 +28,185 @@ const (
 	//Prefix for Web Socket
 	// OUTPUT channel
 	ws_OUT = "WS_OUT"
+
+	// Prefix for Web Socket
+	// lifecycle events channel
+	ws_EVENT = "WS_EVENT"
+
+	// Prefix for Web Socket
+	// close requests channel
+	ws_CLOSE = "WS_CLOSE"
+
+	// Prefix for Web Socket
+	// room membership channel
+	ws_ROOMS = "WS_ROOMS"
+
+	// Prefixes for room
+	// broadcast and presence
+	// channels
+	ws_ROOM     = "WS_ROOM."
+	ws_PRESENCE = "WS_PRESENCE."
 )
 
 // URLToNats builds the channel name
-// from an URL and Method of http.Request
+// from an URL and Method of http.Request.
+// The method and each path segment are
+// encoded to the subject tokens by
+// EncodeSubjectToken.
 func URLToNats(method string, urlPath string) string {
-	subURL := strings.Replace(urlPath, "/", ".", -1)
-	subURL = fmt.Sprintf("%s:%s", method, subURL)
-	return subURL
+	segments := pathSegments(urlPath)
+	for i, segment := range segments {
+		segments[i] = EncodeSubjectToken(segment)
+	}
+	return EncodeSubjectToken(method) + ":" + strings.Join(segments, ".")
 }
 
 // SubscribeURLToNats buils the subscription
 // channel name with placeholders (started with ":").
-// The placeholders are than used to obtain path variables
+// The placeholders are than used to obtain path variables.
+// The catch-all placeholder (started with "*")
+// is mapped to the ">" wildcard. The static
+// segments are encoded the same way as by URLToNats.
 func SubscribeURLToNats(method string, urlPath string) string {
-	subURL := pathrgxp.ReplaceAllString(urlPath, "*")
-	// subURL = lastpathrgxp.ReplaceAllString(subURL, ".*")
-	subURL = strings.Replace(subURL, "/", ".", -1)
-	subURL = fmt.Sprintf("%s:%s", method, subURL)
-	return subURL
+	segments := pathSegments(urlPath)
+	for i, segment := range segments {
+		if isParam(segment) {
+			segments[i] = "*"
+		} else if isCatchAll(segment) {
+			segments[i] = ">"
+		} else {
+			segments[i] = EncodeSubjectToken(segment)
+		}
+	}
+	return EncodeSubjectToken(method) + ":" + strings.Join(segments, ".")
+}
+
+// NatsToURL reverts the URLToNats, it
+// returns the method and the URL path
+// of the subject.
+func NatsToURL(subject string) (method string, urlPath string, err error) {
+	i := strings.Index(subject, ":")
+	if i < 0 {
+		return "", "", ErrInvalidSubject
+	}
+	if method, err = DecodeSubjectToken(subject[:i]); err != nil {
+		return "", "", err
+	}
+	segments := strings.Split(subject[i+1:], ".")
+	for j, token := range segments {
+		if segments[j], err = DecodeSubjectToken(token); err != nil {
+			return "", "", err
+		}
+	}
+	return method, strings.Join(segments, "/"), nil
+}
+
+// isParam returns true for
+// the single segment placeholder.
+func isParam(segment string) bool {
+	return len(segment) > 1 && segment[0] == ':'
+}
+
+// isCatchAll returns true for the
+// placeholder of the path remainder.
+func isCatchAll(segment string) bool {
+	return len(segment) > 1 && segment[0] == '*'
+}
+
+// validRoute checks that the catch-all
+// placeholder is the last segment.
+func validRoute(urlPath string) error {
+	segments := pathSegments(urlPath)
+	for i, segment := range segments {
+		if isCatchAll(segment) && i != len(segments)-1 {
+			return ErrInvalidRoute
+		}
+	}
+	return nil
+}
+
+// pathSegments splits the URL path
+// to the segments. The segment indexes
+// are shared by the subject tokens and
+// path variables.
+func pathSegments(urlPath string) []string {
+	return strings.Split(urlPath, "/")
+}
+
+// EncodeSubjectToken encodes the path
+// segment to the NATS subject token. The
+// ".", "*", ">", "%", whitespace, control and
+// non-ASCII bytes are escaped as "%XX" with
+// the uppercase hex value of the byte, so the
+// token is never split or taken as wildcard.
+func EncodeSubjectToken(segment string) string {
+	n := 0
+	for i := 0; i < len(segment); i++ {
+		if escapeSubjectByte(segment[i]) {
+			n++
+		}
+	}
+	if n == 0 {
+		return segment
+	}
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(segment)+2*n)
+	for i := 0; i < len(segment); i++ {
+		b := segment[i]
+		if escapeSubjectByte(b) {
+			buf = append(buf, '%', hex[b>>4], hex[b&0x0f])
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return string(buf)
+}
+
+// DecodeSubjectToken reverts the
+// EncodeSubjectToken. The ErrInvalidSubject
+// is returned for malformed escapes.
+func DecodeSubjectToken(token string) (string, error) {
+	if strings.IndexByte(token, '%') < 0 {
+		return token, nil
+	}
+	buf := make([]byte, 0, len(token))
+	for i := 0; i < len(token); i++ {
+		if token[i] != '%' {
+			buf = append(buf, token[i])
+			continue
+		}
+		if i+2 >= len(token) {
+			return "", ErrInvalidSubject
+		}
+		hi, ok1 := unhex(token[i+1])
+		lo, ok2 := unhex(token[i+2])
+		if !ok1 || !ok2 {
+			return "", ErrInvalidSubject
+		}
+		buf = append(buf, hi<<4|lo)
+		i += 2
+	}
+	return string(buf), nil
+}
+
+func escapeSubjectByte(b byte) bool {
+	switch b {
+	case '.', '*', '>', '%':
+		return true
+	}
+	return b <= ' ' || b >= 0x7f
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
 }
 
 // copy the values into protocol buffer
@@ -2,6 +2,7 @@ package natsproxy
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/nats-io/nats"
@@ -16,6 +17,19 @@ func TestUrlReplace(t *testing.T) {
 	}
 }
 
+func TestCatchAllReplace(t *testing.T) {
+	res := SubscribeURLToNats("GET", "/static/:version/*filepath")
+	if res != "GET:.static.*.>" {
+		t.Errorf("Catch-all subject assertion failed: %s", res)
+	}
+	if validRoute("/static/*filepath/edit") != ErrInvalidRoute {
+		t.Error("Catch-all in the middle assertion failed")
+	}
+	if validRoute("/static/*filepath") != nil {
+		t.Error("Valid route assertion failed")
+	}
+}
+
 func TestTestConnection(t *testing.T) {
 
 	clientConn, _ := nats.Connect(nats_url)
@@ -29,3 +43,73 @@ func TestTestConnection(t *testing.T) {
 	}
 
 }
+
+func TestURLToNatsEncoding(t *testing.T) {
+	cases := map[string]string{
+		"/files/report.v2.pdf": "GET:.files.report%2Ev2%2Epdf",
+		"/a/*/>":               "GET:.a.%2A.%3E",
+		"/with space/100%":     "GET:.with%20space.100%25",
+		"/čaj":                 "GET:.%C4%8Daj",
+		"/plain/path":          "GET:.plain.path",
+	}
+	for path, subject := range cases {
+		if res := URLToNats("GET", path); res != subject {
+			t.Errorf("Encoding of %q assertion failed: %s", path, res)
+		}
+	}
+
+	// The static segments of the route
+	// are encoded the same way.
+	if res := SubscribeURLToNats("GET", "/files/report.v2.pdf/:name"); res != "GET:.files.report%2Ev2%2Epdf.*" {
+		t.Errorf("Subscribe encoding assertion failed: %s", res)
+	}
+}
+
+func TestNatsToURL(t *testing.T) {
+	method, path, err := NatsToURL("GET:.files.report%2Ev2%2epdf")
+	if err != nil || method != "GET" || path != "/files/report.v2.pdf" {
+		t.Errorf("Decoding assertion failed: %s %s %v", method, path, err)
+	}
+	for _, subject := range []string{".files", "GET:.bad%2", "GET:.bad%zz"} {
+		if _, _, err := NatsToURL(subject); err != ErrInvalidSubject {
+			t.Errorf("Invalid subject %q assertion failed", subject)
+		}
+	}
+}
+
+func FuzzSubjectToken(f *testing.F) {
+	for _, seed := range []string{"", "plain", "report.v2.pdf", "*", ">", "a b\t", "100%", "čaj", "%2E"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, segment string) {
+		token := EncodeSubjectToken(segment)
+		for i := 0; i < len(token); i++ {
+			if c := token[i]; c == '.' || c == '*' || c == '>' || c <= ' ' || c >= 0x7f {
+				t.Fatalf("Token %q of %q contains %q", token, segment, c)
+			}
+		}
+		decoded, err := DecodeSubjectToken(token)
+		if err != nil || decoded != segment {
+			t.Fatalf("Round trip of %q failed: %q %v", segment, decoded, err)
+		}
+	})
+}
+
+func FuzzURLToNats(f *testing.F) {
+	f.Add("GET", "/files/report.v2.pdf")
+	f.Add("POST", "/a/*/>")
+	f.Add("PUT", "/with space/%/čaj/")
+	f.Fuzz(func(t *testing.T, method, path string) {
+		if strings.Contains(method, ":") {
+			t.Skip()
+		}
+		subject := URLToNats(method, path)
+		if strings.Count(subject, ".") != strings.Count(path, "/") {
+			t.Fatalf("Tokens of %q do not match the segments of %q", subject, path)
+		}
+		m, p, err := NatsToURL(subject)
+		if err != nil || m != method || p != path {
+			t.Fatalf("Round trip of %q %q failed: %q %q %v", method, path, m, p, err)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package natsproxy
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats"
+)
+
+// Types of the websocket
+// lifecycle events.
+const (
+	WebsocketOpen  = "open"
+	WebsocketClose = "close"
+	WebsocketError = "error"
+)
+
+// Types of the websocket messages,
+// same as the message types of the
+// gorilla/websocket package.
+const (
+	WebsocketText   = websocket.TextMessage
+	WebsocketBinary = websocket.BinaryMessage
+)
+
+// websocketCloseTimeout is the time the
+// proxy waits for the peer to answer
+// the close frame.
+const websocketCloseTimeout = 5 * time.Second
+
+// WebsocketEventHandler is called
+// on the lifecycle events of the websocket.
+type WebsocketEventHandler func(event *WebsocketEvent)
+
+// WebsocketMessageHandler is called
+// with the message received from the websocket.
+type WebsocketMessageHandler func(msg *WebsocketMessage)
+
+// HandleWebsocketEvents registers the handler
+// of the lifecycle events of the websocket.
+// The open event is sent after the upgrade,
+// the close event carries the close code and
+// reason. After the close event all handlers
+// of the websocket are removed.
+func (nc *NatsClient) HandleWebsocketEvents(webSocketID string, handler WebsocketEventHandler) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.closed {
+		return ErrNatsClientClosed
+	}
+	if err := nc.watchWebsocket(webSocketID); err != nil {
+		return err
+	}
+	nc.wsEvents[webSocketID] = append(nc.wsEvents[webSocketID], handler)
+	return nil
+}
+
+// CloseWebsocket asks the proxy to
+// close the websocket with given close
+// code and reason.
+func (nc *NatsClient) CloseWebsocket(webSocketID string, code int, reason string) error {
+	data, err := proto.Marshal(&WebsocketEvent{
+		WebsocketID: webSocketID,
+		Type:        WebsocketClose,
+		Code:        int32(code),
+		Reason:      reason,
+	})
+	if err != nil {
+		return err
+	}
+	return nc.conn.Publish(ws_CLOSE+webSocketID, data)
+}
+
+// watchWebsocket subscribes the lifecycle
+// events of the websocket once, so the
+// handlers are removed when it closes.
+// Must be called with nc.mu held.
+func (nc *NatsClient) watchWebsocket(webSocketID string) error {
+	if _, ok := nc.wsEvents[webSocketID]; ok {
+		return nil
+	}
+	sub, err := nc.conn.Subscribe(ws_EVENT+webSocketID, func(m *nats.Msg) {
+		nc.dispatchWebsocketEvent(webSocketID, m)
+	})
+	if err != nil {
+		return err
+	}
+	nc.wsEvents[webSocketID] = nil
+	nc.wsSubs[webSocketID] = append(nc.wsSubs[webSocketID], sub)
+	return nil
+}
+
+func (nc *NatsClient) dispatchWebsocketEvent(webSocketID string, m *nats.Msg) {
+	event := &WebsocketEvent{}
+	if err := proto.Unmarshal(m.Data, event); err != nil {
+		nc.logger.Error("cannot decode websocket event", "websocket_id", webSocketID, "error", err)
+		return
+	}
+	atomic.AddInt64(&nc.inFlight, 1)
+	defer atomic.AddInt64(&nc.inFlight, -1)
+
+	nc.mu.Lock()
+	handlers := nc.wsEvents[webSocketID]
+	nc.mu.Unlock()
+	for _, handler := range handlers {
+		nc.runWebsocketEventHandler(handler, event)
+	}
+	if event.Type == WebsocketClose {
+		nc.RemoveWebsocketHandler(webSocketID)
+	}
+}
+
+func (nc *NatsClient) runWebsocketEventHandler(handler WebsocketEventHandler, event *WebsocketEvent) {
+	defer nc.recoverMsg()
+	handler(event)
+}
+
+func (np *NatsProxy) publishWebsocketEvent(event *WebsocketEvent) {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		np.logger.Error("cannot encode websocket event", "websocket_id", event.WebsocketID, "error", err)
+		return
+	}
+	np.conn.Publish(ws_EVENT+event.WebsocketID, data)
+}
+
+// abortWebsocket notifies the service
+// the websocket it accepted could not be
+// opened, so its handlers are removed.
+func (np *NatsProxy) abortWebsocket(wsID string, err error) {
+	np.publishWebsocketEvent(&WebsocketEvent{
+		WebsocketID: wsID,
+		Type:        WebsocketError,
+		Error:       err.Error(),
+	})
+	np.publishWebsocketEvent(&WebsocketEvent{
+		WebsocketID: wsID,
+		Type:        WebsocketClose,
+		Code:        websocket.CloseAbnormalClosure,
+	})
+}
+
+// closeWebsocket sends the close frame
+// requested by the service and gives the
+// peer the time to answer it.
+func closeWebsocket(conn *websocket.Conn, data []byte) (*WebsocketEvent, error) {
+	event := &WebsocketEvent{}
+	if err := proto.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	if event.Code == 0 {
+		event.Code = websocket.CloseNormalClosure
+	}
+	deadline := time.Now().Add(websocketCloseTimeout)
+	msg := websocket.FormatCloseMessage(int(event.Code), event.Reason)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+		conn.Close()
+		return event, nil
+	}
+	conn.SetReadDeadline(deadline)
+	return event, nil
+}
+
+// proxyWebsocket is the websocket upgraded
+// by the proxy. The writes are serialized,
+// because the messages come from the
+// websocket and room subscriptions.
+type proxyWebsocket struct {
+	id        string
+	requestID string // ID of the upgrade request
+	conn      *websocket.Conn
+	writeMu   sync.Mutex
+	rooms     map[string]struct{} // guarded by roomRegistry.mu
+	closed    bool                // guarded by roomRegistry.mu
+	metrics   *Metrics
+}
+
+func newProxyWebsocket(conn *websocket.Conn, wsID string) *proxyWebsocket {
+	return &proxyWebsocket{
+		id:    wsID,
+		conn:  conn,
+		rooms: make(map[string]struct{}),
+	}
+}
+
+func (ws *proxyWebsocket) write(data []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if err := writeWebsocketMessage(ws.conn, data); err != nil {
+		return err
+	}
+	ws.metrics.websocketMessage("out")
+	return nil
+}
+
+// decodeWebsocketMessage decodes the message
+// envelope. The payload which is not the
+// envelope, e.g. the raw bytes published by
+// the older version, is the text message.
+func decodeWebsocketMessage(data []byte) *WebsocketMessage {
+	msg := &WebsocketMessage{}
+	if err := proto.Unmarshal(data, msg); err == nil && msg.Type >= 0 && msg.Type <= WebsocketBinary {
+		// The envelope is marshalled
+		// canonically, the raw bytes
+		// decoded by chance are not.
+		if envelope, err := proto.Marshal(msg); err == nil && bytes.Equal(envelope, data) {
+			return msg
+		}
+	}
+	return &WebsocketMessage{Type: WebsocketText, Data: data}
+}
+
+// writeWebsocketMessage writes the message
+// envelope published by the service. The
+// messages without type are written as text.
+func writeWebsocketMessage(conn *websocket.Conn, data []byte) error {
+	msg := decodeWebsocketMessage(data)
+	msgType := int(msg.Type)
+	if msgType == 0 {
+		msgType = WebsocketText
+	}
+	return conn.WriteMessage(msgType, msg.Data)
+}
+
+// readWebsocketMessage reads the message
+// and wraps it in the envelope with its type.
+func readWebsocketMessage(conn *websocket.Conn) ([]byte, error) {
+	msgType, p, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&WebsocketMessage{
+		Type: int32(msgType),
+		Data: p,
+	})
+}
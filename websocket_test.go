@@ -0,0 +1,233 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats"
+)
+
+func TestWebsocketLifecycle(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	events := make(chan *WebsocketEvent, 10)
+	natsClient.GET("/lifecycle/:action", func(c *Context) {
+		if !c.Request.IsWebSocket() {
+			return
+		}
+		c.Response.DoUpgrade = true
+		socketID, _ := c.GetWebsocketID()
+		kick := c.PathVariable("action") == "kick"
+		natsClient.HandleWebsocket(socketID, func(m *nats.Msg) {})
+		natsClient.HandleWebsocketEvents(socketID, func(e *WebsocketEvent) {
+			events <- e
+			if kick && e.Type == WebsocketOpen {
+				natsClient.CloseWebsocket(socketID, 4001, "kicked")
+			}
+		})
+	})
+	clientConn.Flush()
+
+	nextEvent := func() *WebsocketEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("Event not received")
+		}
+		return nil
+	}
+
+	// The browser closes
+	// the websocket.
+	addr := strings.Replace(server.URL, "http", "ws", -1)
+	conn, _, err := websocket.DefaultDialer.Dial(addr+"/lifecycle/stay", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(); e.Type != WebsocketOpen {
+		t.Error("Open event assertion failed")
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "bye"))
+	conn.Close()
+	if e := nextEvent(); e.Type != WebsocketClose || e.Code != 4000 || e.Reason != "bye" {
+		t.Errorf("Close event assertion failed: %v", e)
+	}
+
+	// The service closes
+	// the websocket.
+	conn, _, err = websocket.DefaultDialer.Dial(addr+"/lifecycle/kick", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	nextEvent()
+	_, _, err = conn.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != 4001 || closeErr.Text != "kicked" {
+		t.Errorf("Close frame assertion failed: %v", err)
+	}
+	if e := nextEvent(); e.Type != WebsocketClose || e.Code != 4001 {
+		t.Errorf("Close event assertion failed: %v", e)
+	}
+
+	// Both sides
+	// clean up.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		natsClient.mu.Lock()
+		remaining := len(natsClient.wsSubs) + len(natsClient.wsEvents)
+		natsClient.mu.Unlock()
+		if remaining == 0 && proxyHandler.wsMapper.len() == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Websocket cleanup assertion failed")
+}
+
+func TestWebsocketUpgradeFailed(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+
+	socketIDs := make(chan string, 1)
+	events := make(chan *WebsocketEvent, 10)
+	natsClient.GET("/upgrade/failed", func(c *Context) {
+		c.Response.DoUpgrade = true
+		socketID, _ := c.GetWebsocketID()
+		natsClient.HandleWebsocket(socketID, func(m *nats.Msg) {})
+		natsClient.HandleWebsocketEvents(socketID, func(e *WebsocketEvent) {
+			events <- e
+		})
+		clientConn.Flush()
+		socketIDs <- socketID
+	})
+	clientConn.Flush()
+
+	// The handshake without the
+	// key cannot be upgraded.
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000/upgrade/failed", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	proxyHandler.ServeHTTP(httptest.NewRecorder(), req)
+	socketID := <-socketIDs
+
+	for _, expected := range []string{WebsocketError, WebsocketClose} {
+		select {
+		case e := <-events:
+			if e.Type != expected {
+				t.Fatalf("Event %s assertion failed: %s", expected, e.Type)
+			}
+			if e.Type == WebsocketClose && e.Code != websocket.CloseAbnormalClosure {
+				t.Errorf("Close code assertion failed: %d", e.Code)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Event %s not received", expected)
+		}
+	}
+
+	// The handlers are removed
+	// after the close event.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		natsClient.mu.Lock()
+		_, ok := natsClient.wsSubs[socketID]
+		natsClient.mu.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Websocket handlers leaked")
+}
+
+func TestWebsocketMessageTypes(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	socketIDs := make(chan string, 1)
+	natsClient.GET("/echo", func(c *Context) {
+		c.Response.DoUpgrade = c.Request.IsWebSocket()
+		socketID, _ := c.GetWebsocketID()
+		socketIDs <- socketID
+		natsClient.HandleWebsocketMessage(socketID, func(msg *WebsocketMessage) {
+			if msg.Type == WebsocketBinary {
+				natsClient.WriteWebsocketBinary(socketID, msg.Data)
+			} else {
+				natsClient.WriteWebsocketText(socketID, msg.Data)
+			}
+		})
+	})
+	clientConn.Flush()
+
+	addr := strings.Replace(server.URL, "http", "ws", -1)
+	conn, _, err := websocket.DefaultDialer.Dial(addr+"/echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	messages := []struct {
+		msgType int
+		data    string
+	}{
+		{websocket.BinaryMessage, "\x00\x01\x02\xff"},
+		{websocket.TextMessage, "Hello"},
+	}
+	for _, sent := range messages {
+		conn.WriteMessage(sent.msgType, []byte(sent.data))
+		msgType, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgType != sent.msgType || string(p) != sent.data {
+			t.Errorf("Message type assertion failed: %d %q", msgType, p)
+		}
+	}
+
+	// The raw bytes without
+	// envelope are written as text.
+	clientConn.Publish(ws_OUT+<-socketIDs, []byte("raw"))
+	msgType, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != websocket.TextMessage || string(p) != "raw" {
+		t.Errorf("Raw message assertion failed: %d %q", msgType, p)
+	}
+}
+
+func TestDecodeWebsocketMessage(t *testing.T) {
+	envelope, _ := proto.Marshal(&WebsocketMessage{Type: WebsocketBinary, Data: []byte("data")})
+	if msg := decodeWebsocketMessage(envelope); msg.Type != WebsocketBinary || string(msg.Data) != "data" {
+		t.Errorf("Envelope assertion failed: %v", msg)
+	}
+	for _, raw := range []string{"hello", `{"a":1}`, "\x08\x01\x08\x02", "\x08\x07"} {
+		if msg := decodeWebsocketMessage([]byte(raw)); msg.Type != WebsocketText || string(msg.Data) != raw {
+			t.Errorf("Raw message %q assertion failed: %v", raw, msg)
+		}
+	}
+}
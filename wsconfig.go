@@ -0,0 +1,204 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultWebsocketPingInterval is
+	// the interval of pings sent by the
+	// proxy to the idle websocket.
+	DefaultWebsocketPingInterval = 30 * time.Second
+
+	// DefaultWebsocketIdleTimeout is the
+	// time the websocket could stay without
+	// any message or pong before it is closed.
+	DefaultWebsocketIdleTimeout = 60 * time.Second
+
+	// subprotocolHeader carries the
+	// subprotocol chosen by the service.
+	subprotocolHeader = "Sec-Websocket-Protocol"
+)
+
+// WebsocketConfig configures the
+// websocket upgrade and the upgraded
+// connections of NatsProxy.
+type WebsocketConfig struct {
+	// AllowedOrigins lists the origins allowed
+	// to open the websocket, either exact like
+	// "https://app.example.com" or with wildcard
+	// like "https://*.example.com". The "*" allows
+	// any origin. If empty, only the requests
+	// from the same host are allowed.
+	AllowedOrigins []string
+
+	// Subprotocols supported by the proxy in
+	// order of preference. The subprotocol chosen
+	// by the service has precedence.
+	Subprotocols []string
+
+	// EnableCompression negotiates
+	// the permessage-deflate extension.
+	EnableCompression bool
+
+	// ReadLimit is the maximal size of the
+	// message read from the websocket,
+	// zero means no limit.
+	ReadLimit int64
+
+	ReadBufferSize   int
+	WriteBufferSize  int
+	HandshakeTimeout time.Duration
+
+	// PingInterval is the interval of pings,
+	// the IdleTimeout is the time the websocket
+	// is kept open without any message or pong.
+	// Zero disables the keepalive.
+	PingInterval time.Duration
+	IdleTimeout  time.Duration
+}
+
+// DefaultWebsocketConfig returns the
+// configuration allowing only the same
+// origin with the default keepalive.
+func DefaultWebsocketConfig() WebsocketConfig {
+	return WebsocketConfig{
+		PingInterval: DefaultWebsocketPingInterval,
+		IdleTimeout:  DefaultWebsocketIdleTimeout,
+	}
+}
+
+// SetWebsocketConfig sets the configuration
+// of the websockets upgraded by the proxy.
+// The error is returned if the allowed origin
+// pattern is malformed.
+func (np *NatsProxy) SetWebsocketConfig(config WebsocketConfig) error {
+	for _, origin := range config.AllowedOrigins {
+		if _, err := path.Match(strings.ToLower(origin), ""); err != nil {
+			return err
+		}
+	}
+	np.websocketConfig = config
+	return nil
+}
+
+// upgrader builds the upgrader for the
+// request, the subprotocol chosen by the
+// service in response header is used
+// if the client requested it.
+func (np *NatsProxy) upgrader(req *http.Request, header http.Header) *websocket.Upgrader {
+	config := np.websocketConfig
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:    config.ReadBufferSize,
+		WriteBufferSize:   config.WriteBufferSize,
+		HandshakeTimeout:  config.HandshakeTimeout,
+		EnableCompression: config.EnableCompression,
+		Subprotocols:      config.Subprotocols,
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return checkOrigin(config, r)
+	}
+	if chosen := header.Get(subprotocolHeader); chosen != "" {
+		header.Del(subprotocolHeader)
+		for _, requested := range websocket.Subprotocols(req) {
+			if requested == chosen {
+				upgrader.Subprotocols = []string{chosen}
+			}
+		}
+	}
+	return upgrader
+}
+
+// checkOrigin reports whether the websocket
+// handshake comes from the allowed origin.
+// Without AllowedOrigins only the same
+// host is allowed, like websocket.Upgrader does.
+func checkOrigin(config WebsocketConfig, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(config.AllowedOrigins) > 0 {
+		return originAllowed(config.AllowedOrigins, origin)
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// originAllowed matches the origin
+// against the allowed origins. The request
+// without Origin header does not come
+// from the browser and is allowed.
+func originAllowed(allowed []string, origin string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keepAlive applies the read limit and
+// the idle timeout to the connection and
+// pings it until done is closed.
+func (config WebsocketConfig) keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	if config.ReadLimit > 0 {
+		conn.SetReadLimit(config.ReadLimit)
+	}
+	if config.IdleTimeout <= 0 {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(config.IdleTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.IdleTimeout))
+	})
+	if config.PingInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(config.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				deadline := time.Now().Add(config.PingInterval)
+				if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// WebsocketSubprotocols returns the
+// subprotocols requested by the client.
+func (c *Context) WebsocketSubprotocols() []string {
+	var protocols []string
+	for _, p := range strings.Split(c.HeaderVariable(subprotocolHeader), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+	return protocols
+}
+
+// SetWebsocketSubprotocol chooses the
+// subprotocol of the upgraded websocket.
+func (c *Context) SetWebsocketSubprotocol(protocol string) {
+	c.Response.GetHeader().Set(subprotocolHeader, protocol)
+}
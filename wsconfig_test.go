@@ -0,0 +1,172 @@
+package natsproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.example.org"}
+	cases := map[string]bool{
+		"":                          true,
+		"https://app.example.com":   true,
+		"HTTPS://APP.EXAMPLE.COM":   true,
+		"https://a.example.org":     true,
+		"https://evil.com":          false,
+		"http://app.example.com":    false,
+		"https://example.org":       false,
+		"https://a.example.org:444": false,
+	}
+	for origin, expected := range cases {
+		if originAllowed(allowed, origin) != expected {
+			t.Errorf("Origin %q assertion failed", origin)
+		}
+	}
+	if !originAllowed([]string{"*"}, "https://evil.com") {
+		t.Error("Any origin assertion failed")
+	}
+}
+
+func TestForbiddenOriginNotProxied(t *testing.T) {
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	called := make(chan struct{}, 4)
+	natsClient.GET("/origin/checked", func(c *Context) {
+		called <- struct{}{}
+		c.Response.DoUpgrade = c.Request.IsWebSocket()
+	})
+	clientConn.Flush()
+
+	// The default proxy allows only the
+	// same origin, the configured one
+	// the allowed origins.
+	var addrs []string
+	for _, config := range []WebsocketConfig{{}, {AllowedOrigins: []string{"https://*.example.com"}}} {
+		proxyConn, _ := nats.Connect(nats_url)
+		defer proxyConn.Close()
+		proxyHandler, _ := NewNatsProxy(proxyConn)
+		proxyHandler.SetWebsocketConfig(config)
+		server := httptest.NewServer(proxyHandler)
+		defer server.Close()
+		addrs = append(addrs, server.URL)
+	}
+	dial := func(server, origin string) int {
+		addr := strings.Replace(server, "http", "ws", -1) + "/origin/checked"
+		conn, resp, err := websocket.DefaultDialer.Dial(addr, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return 0
+		}
+		return resp.StatusCode
+	}
+
+	if status := dial(addrs[0], "https://evil.com"); status != http.StatusForbidden {
+		t.Errorf("Cross-site origin status assertion failed: %d", status)
+	}
+	if status := dial(addrs[1], "https://evil.com"); status != http.StatusForbidden {
+		t.Errorf("Forbidden origin status assertion failed: %d", status)
+	}
+	select {
+	case <-called:
+		t.Error("Forbidden handshake proxied to the service")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if status := dial(addrs[0], addrs[0]); status != http.StatusSwitchingProtocols {
+		t.Errorf("Same origin status assertion failed: %d", status)
+	}
+	if status := dial(addrs[1], "https://app.example.com"); status != http.StatusSwitchingProtocols {
+		t.Errorf("Allowed origin status assertion failed: %d", status)
+	}
+}
+
+func TestSetWebsocketConfig(t *testing.T) {
+	np := &NatsProxy{}
+	if err := np.SetWebsocketConfig(WebsocketConfig{AllowedOrigins: []string{"https://[.com"}}); err == nil {
+		t.Error("Malformed origin assertion failed")
+	}
+}
+
+func TestWebsocketConfig(t *testing.T) {
+	proxyConn, _ := nats.Connect(nats_url)
+	defer proxyConn.Close()
+	proxyHandler, _ := NewNatsProxy(proxyConn)
+	proxyHandler.SetWebsocketConfig(WebsocketConfig{
+		AllowedOrigins: []string{"https://*.example.com"},
+		PingInterval:   50 * time.Millisecond,
+		IdleTimeout:    200 * time.Millisecond,
+	})
+	server := httptest.NewServer(proxyHandler)
+	defer server.Close()
+
+	clientConn, _ := nats.Connect(nats_url)
+	defer clientConn.Close()
+	natsClient, _ := NewNatsClient(clientConn)
+	closed := make(chan *WebsocketEvent, 1)
+	natsClient.GET("/configured", func(c *Context) {
+		c.Response.DoUpgrade = c.Request.IsWebSocket()
+		for _, protocol := range c.WebsocketSubprotocols() {
+			if protocol == "v2" {
+				c.SetWebsocketSubprotocol(protocol)
+			}
+		}
+		socketID, _ := c.GetWebsocketID()
+		natsClient.HandleWebsocketEvents(socketID, func(e *WebsocketEvent) {
+			if e.Type == WebsocketClose {
+				closed <- e
+			}
+		})
+	})
+	clientConn.Flush()
+
+	addr := strings.Replace(server.URL, "http", "ws", -1) + "/configured"
+	dialer := &websocket.Dialer{Subprotocols: []string{"v1", "v2"}}
+
+	_, resp, err := dialer.Dial(addr, http.Header{"Origin": {"https://evil.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Error("Forbidden origin assertion failed")
+	}
+
+	conn, _, err := dialer.Dial(addr, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "v2" {
+		t.Errorf("Subprotocol assertion failed: %q", conn.Subprotocol())
+	}
+
+	// The client answering the pings
+	// is kept open past the idle timeout.
+	go conn.ReadMessage()
+	select {
+	case <-closed:
+		t.Fatal("Alive websocket closed")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// The client not answering
+	// the pings is closed.
+	silent, _, err := dialer.Dial(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	select {
+	case e := <-closed:
+		if e.Code != websocket.CloseAbnormalClosure {
+			t.Errorf("Idle close code assertion failed: %d", e.Code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Idle websocket assertion failed")
+	}
+}